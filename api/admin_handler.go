@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/response"
+)
+
+// RequireAdmin only lets requests with a valid bearer token of a user in one of the admin groups through
+func RequireAdmin(config *types.ConfigMap, next http.HandlerFunc) http.HandlerFunc {
+	adminGroups := map[string]bool{}
+	for _, group := range config.AuthConfig.AdminGroups {
+		adminGroups[group] = true
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(adminGroups) == 0 {
+			response.Send(http.StatusForbidden, fmt.Errorf("Admin APIs are disabled, configure authConfig.adminGroups to enable them"), nil, w)
+			return
+		}
+		user, err := auth.ValidateAuthorizationHeader(r.Header.Get("Authorization"))
+		if err != nil {
+			response.Send(http.StatusUnauthorized, fmt.Errorf("Need valid JWT bearer token in Authorization header"), nil, w)
+			return
+		}
+		for _, group := range user.Groups {
+			if adminGroups[group] {
+				log.SetUsername(r, user.Username)
+				log.WithRequest(r).Infof("Admin request %s %s by %s", r.Method, r.URL.Path, user.Username)
+				next(w, r)
+				return
+			}
+		}
+		log.SetUsername(r, user.Username)
+		log.WithRequest(r).Warnf("Admin request %s %s by %s rejected, user is not in an admin group", r.Method, r.URL.Path, user.Username)
+		response.Send(http.StatusForbidden, fmt.Errorf("User %s is not an admin", user.Username), nil, w)
+	}
+}
+
+// KeyStatusHandler lists the keys used to sign and verify tokens
+func KeyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	response.SendJSON(http.StatusOK, auth.KeyStatus(), w)
+}
+
+// RotateKeysHandler reloads the signing keys from the config file without waiting for the next change check
+func RotateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ReloadKeys(); err != nil {
+		log.WithRequest(r).Errorf("Reloading signing keys failed, keeping the current keys : %v", err)
+		response.Send(http.StatusInternalServerError, fmt.Errorf("Reloading signing keys failed : %v", err), nil, w)
+		return
+	}
+	response.SendJSON(http.StatusOK, auth.KeyStatus(), w)
+}
+
+// RevocationsHandler lists the revoked tokens and users
+func RevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	response.SendJSON(http.StatusOK, auth.Revocations(), w)
+}
+
+// LockoutsHandler lists the usernames and source IPs with failed logins
+func LockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	response.SendJSON(http.StatusOK, auth.LoginLockouts(), w)
+}
+
+// ClearLockoutHandler clears the failed logins of the username or source IP in the request body
+func ClearLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	var request types.ClearLockoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (request.Username == "" && request.SourceIP == "" && !request.All) {
+		response.Send(http.StatusBadRequest, fmt.Errorf("Need username, sourceIP or all in the request body"), nil, w)
+		return
+	}
+	auth.ClearLoginLockout(request.Username, request.SourceIP, request.All)
+	response.SendJSON(http.StatusOK, auth.LoginLockouts(), w)
+}
+
+// RevokeUserHandler revokes all tokens issued to the user in the request body
+func RevokeUserHandler(w http.ResponseWriter, r *http.Request) {
+	var request types.RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Username == "" {
+		response.Send(http.StatusBadRequest, fmt.Errorf("Need username in the request body"), nil, w)
+		return
+	}
+	if err := auth.RevokeUser(request.Username); err != nil {
+		log.WithRequest(r).Errorf("Saving revocation of user %s failed : %v", request.Username, err)
+		response.Send(http.StatusInternalServerError, fmt.Errorf("Saving revocation failed : %v", err), nil, w)
+		return
+	}
+	response.SendJSON(http.StatusOK, auth.Revocations(), w)
+}
+
+// RevokeTokenHandler revokes the token with the jti in the request body
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var request types.RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		response.Send(http.StatusBadRequest, fmt.Errorf("Need jti in the request body"), nil, w)
+		return
+	}
+	if err := auth.RevokeToken(request.ID, request.Username, time.Time{}); err != nil {
+		log.WithRequest(r).Errorf("Saving revocation of token %s failed : %v", request.ID, err)
+		response.Send(http.StatusInternalServerError, fmt.Errorf("Saving revocation failed : %v", err), nil, w)
+		return
+	}
+	response.SendJSON(http.StatusOK, auth.Revocations(), w)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+// auditRecord returns an audit record of the event with the source of the request filled in
+func auditRecord(r *http.Request, event string, outcome string) types.AuditRecord {
+	return types.AuditRecord{
+		Time:      time.Now(),
+		Event:     event,
+		Outcome:   outcome,
+		SourceIP:  remoteIP(r),
+		Caller:    WebhookCaller(r),
+		RequestID: log.RequestID(r),
+	}
+}
+
+// remoteIP returns the IP address of the client connection. Forwarded headers are not trusted
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
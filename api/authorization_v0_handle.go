@@ -6,46 +6,69 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/audit"
+	"github.com/dinumathai/auth-webhook-sample/authz"
 	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 )
 
-// AuthorizeV0Handler -- Handle authentication using property file. For testing only
-func AuthorizeV0Handler(apiVersion auth.Version) http.HandlerFunc {
+const authorizationAPIVersion = "authorization.k8s.io/v1"
+
+// AuthorizeV0Handler -- Handle SubjectAccessReview requests using the policy file in config
+func AuthorizeV0Handler(config *types.ConfigMap) http.HandlerFunc {
+	policy, policyErr := authz.LoadPolicy(config.AuthConfig.AuthorizationPolicyFilePath)
+	if policyErr != nil {
+		log.Errorf("Authorization policy not loaded, all requests will get no opinion : %s", policyErr)
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		defer log.Debugf("AuthorizeV0Handler Elapsed - %s", time.Since(start))
+		logger := log.WithRequest(r)
+		defer func() { log.WithRequest(r).Debugf("AuthorizeV0Handler Elapsed - %s", time.Since(start)) }()
 
 		content, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			log.Debugf("Error in Read of request body : %s", err)
-			sentAuthorizationResponse(w, false, "Error in Read of request body")
+			logger.Debugf("Error in Read of request body : %s", err)
+			sentAuthorizationResponse(w, r, authorizationAPIVersion, nil, authz.Decision{Effect: types.PolicyNoOpinion}, "Error in Read of request body")
 			return
 		}
 		rawContent := json.RawMessage(string(content))
-		log.Debugf("Request body : %s", rawContent)
-		log.Debugf("Request headers : %v", r.Header)
+		logger.Debugf("Request body : %s", rawContent)
+		logger.Debugf("Request headers : %v", log.RedactHeaders(r.Header))
 
-		sentAuthorizationResponse(w, true, "")
+		var request types.AuthorizationRequest
+		if err := json.Unmarshal(content, &request); err != nil || request.Spec == nil {
+			logger.Debugf("Request body is not a SubjectAccessReview : %v", err)
+			sentAuthorizationResponse(w, r, authorizationAPIVersion, nil, authz.Decision{Effect: types.PolicyNoOpinion}, "Request body is not a valid SubjectAccessReview")
+			return
+		}
+		log.SetUsername(r, request.Spec.User)
+		apiVersion := request.APIVersion
+		if apiVersion == "" {
+			apiVersion = authorizationAPIVersion
+		}
+		if policyErr != nil {
+			sentAuthorizationResponse(w, r, apiVersion, request.Spec, authz.Decision{Effect: types.PolicyNoOpinion}, policyErr.Error())
+			return
+		}
+
+		decision := policy.Evaluate(*request.Spec)
+		log.WithRequest(r).Debugf("Authorization decision for user %s : %s - %s", request.Spec.User, decision.Effect, decision.Reason)
+		sentAuthorizationResponse(w, r, apiVersion, request.Spec, decision, "")
 	}
 }
 
-func sentAuthorizationResponse(w http.ResponseWriter, allowed bool, reason string) {
+func sentAuthorizationResponse(w http.ResponseWriter, r *http.Request, apiVersion string, spec *types.AuthorizationSpec, decision authz.Decision, evaluationError string) {
+	recordDecision(r, spec, decision, evaluationError)
 	response := types.AuthorizationResponse{
-		APIVersion: "authorization.k8s.io/v1",
+		APIVersion: apiVersion,
 		Kind:       "SubjectAccessReview",
-	}
-	if allowed {
-		response.Status = &types.AuthorizationStatus{
-			Allowed: true,
-		}
-	} else {
-		response.Status = &types.AuthorizationStatus{
-			Allowed: false,
-			Denied:  true,
-			Reason:  reason,
-		}
+		Status: &types.AuthorizationStatus{
+			Allowed:         decision.Effect == types.PolicyAllow,
+			Denied:          decision.Effect == types.PolicyDeny,
+			Reason:          decision.Reason,
+			EvaluationError: evaluationError,
+		},
 	}
 	responseBytes, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -54,3 +77,31 @@ func sentAuthorizationResponse(w http.ResponseWriter, allowed bool, reason strin
 
 	w.Write(responseBytes)
 }
+
+// recordDecision counts the decision by verb and resource, subresources are counted separately e.g. pods/log,
+// and writes it to the audit log
+func recordDecision(r *http.Request, spec *types.AuthorizationSpec, decision authz.Decision, evaluationError string) {
+	record := auditRecord(r, types.AuditSubjectAccessReview, decision.Effect)
+	record.Reason = decision.Reason
+	if evaluationError != "" {
+		record.Reason = evaluationError
+	}
+	if spec != nil {
+		record.User, record.Groups = spec.User, spec.UserGroups()
+		record.ResourceAttributes, record.NonResourceAttributes = spec.ResourceAttributes, spec.NonResourceAttributes
+	}
+	audit.Log(record)
+
+	var verb, resource string
+	switch {
+	case spec == nil:
+	case spec.ResourceAttributes != nil:
+		verb, resource = spec.ResourceAttributes.Verb, spec.ResourceAttributes.Resource
+		if spec.ResourceAttributes.Subresource != "" {
+			resource += "/" + spec.ResourceAttributes.Subresource
+		}
+	case spec.NonResourceAttributes != nil:
+		verb, resource = spec.NonResourceAttributes.Verb, "nonResource"
+	}
+	metrics.AuthorizationDecision(decision.Effect, verb, resource)
+}
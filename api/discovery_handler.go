@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/response"
+)
+
+// JWKSPath is where the public signing keys are published
+const JWKSPath = "/.well-known/jwks.json"
+
+// OpenIDConfigurationHandler publishes the OpenID provider metadata of this service. Only served with
+// authConfig.issuer set, the issuer is never taken from the request
+func OpenIDConfigurationHandler(config *types.ConfigMap) http.HandlerFunc {
+	issuer := config.AuthConfig.Issuer
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		response.SendJSON(http.StatusOK, types.OpenIDConfiguration{
+			Issuer:                           issuer,
+			JWKSURI:                          issuer + JWKSPath,
+			TokenEndpoint:                    issuer + "/v0/login",
+			ResponseTypesSupported:           []string{"id_token"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: auth.SigningAlgorithms(),
+			ClaimsSupported:                  []string{"iss", "sub", "exp", "iat", "uid", "username", "groups"},
+		}, w)
+	}
+}
+
+// JWKSHandler publishes the public keys used to sign tokens, including the keys kept only for verification
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	response.SendJSON(http.StatusOK, auth.PublicKeys(), w)
+}
@@ -2,57 +2,96 @@ package api
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/dinumathai/auth-webhook-sample/audit"
 	"github.com/dinumathai/auth-webhook-sample/auth"
 	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/userstore"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 	"github.com/dinumathai/auth-webhook-sample/util/response"
-	"gopkg.in/yaml.v2"
 )
 
-var userConfig map[string]types.UserDetails
-
-// LoginV0Handler -- Handle auth using property file. For testing only
-func LoginV0Handler(config *types.ConfigMap) http.HandlerFunc {
+// LoginV0Handler -- Handle auth using the users of the configured user store
+func LoginV0Handler(store userstore.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		defer log.Debugf("LoginV0Handler Elapsed - %s", time.Since(start))
+		defer func() { log.WithRequest(r).Debugf("LoginV0Handler Elapsed - %s", time.Since(start)) }()
 
 		//Check for valid username and password
 		username, password, ok := r.BasicAuth()
 		if !ok {
+			recordLogin(r, username, metrics.LoginFailure, "missing_credentials")
 			sendResponse(http.StatusUnauthorized, "", types.RawAuthResponse{}, fmt.Errorf("Need valid username and password as basic auth"), w)
 			return
 		}
-		userDetailFromConfig, err := validateAndGetUser(config, username, password)
+		log.SetUsername(r, username)
+		// Refuse logins during the backoff after failures without checking the password
+		sourceIP := remoteIP(r)
+		if wait := auth.LoginBlocked(username, sourceIP); wait > 0 {
+			recordLogin(r, username, metrics.LoginFailure, "locked_out")
+			log.WithRequest(r).Warnf("Login of %s from %s refused for %s after failed logins", username, sourceIP, wait.Round(time.Second))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			response.Send(http.StatusTooManyRequests, fmt.Errorf("Too many failed logins, retry later"), nil, w)
+			return
+		}
+		// expires_in(seconds) can only shorten the lifetime allowed for the user
+		var expiresIn time.Duration
+		if value := r.FormValue("expires_in"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				recordLogin(r, username, metrics.LoginFailure, "invalid_request")
+				sendResponse(http.StatusBadRequest, "", types.RawAuthResponse{}, fmt.Errorf("expires_in must be a positive number of seconds"), w)
+				return
+			}
+			expiresIn = time.Duration(seconds) * time.Second
+		}
+		// groups or scope ask for a token with a subset of the user's groups
+		groups, err := auth.ParseGroupClaims(r.FormValue("groups"), r.FormValue("scope"))
 		if err != nil {
-			errHandle(w, fmt.Sprintf("Unable to validate : %s", err), "Authentication failed", 401)
+			recordLogin(r, username, metrics.LoginFailure, "invalid_request")
+			sendResponse(http.StatusBadRequest, "", types.RawAuthResponse{}, err, w)
 			return
 		}
-		user := types.User{
-			Username: userDetailFromConfig.UserName,
-			EMail:    userDetailFromConfig.Email,
-			UID:      userDetailFromConfig.UID,
-			Groups:   userDetailFromConfig.Groups}
-		if user.UID == "" {
-			user.UID = user.Username
+		userDetailFromConfig, err := store.Authenticate(username, password)
+		if err == userstore.ErrInvalidCredentials {
+			auth.LoginFailed(username, sourceIP)
 		}
-		token, err := auth.GenerateToken(user, "", auth.V0)
 		if err != nil {
-			errHandle(w, fmt.Sprintf("Something is wrong with auth token. : %s", err), "Authentication failed", 401)
+			recordLogin(r, username, metrics.LoginFailure, "invalid_credentials")
+			errHandle(w, r, fmt.Sprintf("Unable to validate : %s", err), "Authentication failed", 401)
 			return
 		}
-
-		v1Token := types.V1Token{
-			Token:  token.JWT,
-			Expiry: token.Expiry,
+		auth.LoginSucceeded(username)
+		v1Token, err := newV1Token(userDetailFromConfig, groups, expiresIn)
+		if err == auth.ErrNoMatchingGroups {
+			recordLogin(r, username, metrics.LoginFailure, "no_matching_groups")
+			sendResponse(http.StatusBadRequest, "", types.RawAuthResponse{}, err, w)
+			return
+		}
+		if err != nil {
+			recordLogin(r, username, metrics.LoginFailure, "token_error")
+			errHandle(w, r, fmt.Sprintf("Something is wrong with auth token. : %s", err), "Authentication failed", 401)
+			return
+		}
+		if auth.RefreshTokensEnabled() {
+			// The refresh token looks the user up again by the name used to log in
+			refreshToken, err := auth.IssueRefreshToken(auth.RefreshGrant{UserName: username, Groups: groups, ExpiresIn: expiresIn})
+			if err != nil {
+				recordLogin(r, username, metrics.LoginFailure, "token_error")
+				errHandle(w, r, fmt.Sprintf("Unable to issue refresh token : %s", err), "Authentication failed", 401)
+				return
+			}
+			v1Token.RefreshToken = refreshToken.JWT
+			v1Token.RefreshExpiry = refreshToken.Expiry
 		}
 
+		recordLogin(r, username, metrics.LoginSuccess, "authenticated")
 		data, _ := json.Marshal(v1Token)
 		response := JSONResponse{}
 		response.status = http.StatusCreated
@@ -62,49 +101,38 @@ func LoginV0Handler(config *types.ConfigMap) http.HandlerFunc {
 	}
 }
 
-func validateAndGetUser(config *types.ConfigMap, userName, password string) (types.UserDetails, error) {
-	userDtlMap, err := getV0UserConfig(config)
-	if err != nil {
-		return types.UserDetails{}, err
-	}
-	if userDtl, ok := userDtlMap[userName]; ok {
-		if userDtl.Password == password {
-			if userDtl.UserName == "" {
-				userDtl.UserName = userName
-			}
-			return userDtl, nil
-		} else {
-			return types.UserDetails{}, errors.New("Invalid Credentials")
-		}
-	}
-	return types.UserDetails{}, errors.New("User Not present")
+// recordLogin counts the login attempt and writes it to the audit log
+func recordLogin(r *http.Request, username string, result string, reason string) {
+	metrics.LoginAttempt(result, reason)
+	record := auditRecord(r, types.AuditLogin, result)
+	record.User, record.Reason = username, reason
+	audit.Log(record)
 }
 
-func getV0UserConfig(config *types.ConfigMap) (map[string]types.UserDetails, error) {
-	if config.AuthConfig.V0.UserDetailFilePath == "" {
-		return userConfig, errors.New("Invalid Config")
+// newV1Token issues an access token for the user with the groups matching the patterns in groups.
+// A non zero expiresIn shortens its lifetime
+func newV1Token(userDetail types.UserDetails, groups string, expiresIn time.Duration) (types.V1Token, error) {
+	user := types.User{
+		Username: userDetail.UserName,
+		EMail:    userDetail.Email,
+		UID:      userDetail.UID,
+		Groups:   userDetail.Groups}
+	if user.UID == "" {
+		user.UID = user.Username
 	}
-	//TODO : Avoid subsequent read if first time read/parsing failed
-	if userConfig != nil {
-		return userConfig, nil
-	}
-	data, err := ioutil.ReadFile(config.AuthConfig.V0.UserDetailFilePath)
+	token, err := auth.GenerateToken(user, groups, auth.V0, auth.TokenTTL(userDetail, expiresIn))
 	if err != nil {
-		log.Errorf("User Details config read Failed: %v", err)
-		return userConfig, err
-	}
-	var userConf types.UserDetailsConfig
-	if yamlErr := yaml.Unmarshal(data, &userConf); yamlErr != nil {
-		log.Errorf("Error deserializing yaml %v", yamlErr)
-		return nil, yamlErr
+		return types.V1Token{}, err
 	}
-	userConfig = userConf.UserDetails
-	return userConfig, nil
+	return types.V1Token{
+		Token:  token.JWT,
+		Expiry: token.Expiry,
+	}, nil
 }
 
 // errHandle packages an error into an http response
-func errHandle(w http.ResponseWriter, longmsg string, shortmsg string, status int) {
-	log.Errorf(longmsg)
+func errHandle(w http.ResponseWriter, r *http.Request, longmsg string, shortmsg string, status int) {
+	log.WithRequest(r).Errorf("%s", longmsg)
 	errorResponse := ErrorResponse{
 		Status:       status,
 		ErrorMessage: shortmsg,
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/response"
+)
+
+// LogoutV0Handler revokes the bearer token in the Authorization header and the refresh token in the body, if any
+func LogoutV0Handler(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.Logout(r.Header.Get("Authorization"))
+	if err == auth.ErrTokenRevoked {
+		// Logging out twice is not an error
+		response.Send(http.StatusNoContent, nil, nil, w)
+		return
+	}
+	if err != nil {
+		response.Send(http.StatusUnauthorized, fmt.Errorf("Unable to logout : %v", err), nil, w)
+		return
+	}
+	var request types.RefreshRequest
+	if json.NewDecoder(r.Body).Decode(&request) == nil && request.RefreshToken != "" {
+		auth.RevokeRefreshToken(request.RefreshToken)
+	}
+	log.SetUsername(r, user.Username)
+	log.WithRequest(r).Infof("User %s logged out", user.Username)
+	response.Send(http.StatusNoContent, nil, nil, w)
+}
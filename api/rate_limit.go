@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
+	"github.com/dinumathai/auth-webhook-sample/util/ratelimit"
+	"github.com/dinumathai/auth-webhook-sample/util/response"
+
+	"github.com/gorilla/mux"
+)
+
+// Client identities a rate limit rule can use
+const (
+	RateLimitKeyIP          = "ip"
+	RateLimitKeyCertificate = "certificate"
+	RateLimitKeyToken       = "token"
+)
+
+const rateLimitPruneInterval = time.Minute
+
+// RateLimiter limits the requests per route and client with the rules of authConfig.rateLimit
+type RateLimiter struct {
+	rules   []rateLimitRule
+	callers *WebhookCallers
+	// webhookRoutes are the route names of the webhook endpoints, only there callers are identified
+	webhookRoutes map[string]bool
+	// callerLimiter limits each authenticated kube-apiserver, nil if they are exempt
+	callerLimiter *ratelimit.Limiter
+}
+
+type rateLimitRule struct {
+	routes  map[string]bool
+	key     string
+	limiter *ratelimit.Limiter
+}
+
+// NewRateLimiter builds the limiters of the rules. Requests to the webhookRoutes are identified with callers
+func NewRateLimiter(conf types.RateLimitConfig, callers *WebhookCallers, webhookRoutes ...string) (*RateLimiter, error) {
+	rl := &RateLimiter{callers: callers, webhookRoutes: map[string]bool{}}
+	for _, route := range webhookRoutes {
+		rl.webhookRoutes[route] = true
+	}
+	for i, rule := range conf.Rules {
+		if len(rule.Routes) == 0 {
+			return nil, fmt.Errorf("authConfig.rateLimit.rules[%d] has no routes", i)
+		}
+		if rule.RequestsPerSecond <= 0 {
+			return nil, fmt.Errorf("authConfig.rateLimit.rules[%d].requestsPerSecond must be positive", i)
+		}
+		key := rule.Key
+		switch key {
+		case "":
+			key = RateLimitKeyIP
+		case RateLimitKeyIP, RateLimitKeyCertificate, RateLimitKeyToken:
+		default:
+			return nil, fmt.Errorf("Unsupported authConfig.rateLimit.rules[%d].key %q, expected %s, %s or %s", i, rule.Key,
+				RateLimitKeyIP, RateLimitKeyCertificate, RateLimitKeyToken)
+		}
+		routes := map[string]bool{}
+		for _, route := range rule.Routes {
+			routes[route] = true
+		}
+		rl.rules = append(rl.rules, rateLimitRule{routes: routes, key: key, limiter: ratelimit.New(rule.RequestsPerSecond, rule.Burst)})
+	}
+	if conf.WebhookCallerLimit != nil {
+		if conf.WebhookCallerLimit.RequestsPerSecond <= 0 {
+			return nil, fmt.Errorf("authConfig.rateLimit.webhookCallerLimit.requestsPerSecond must be positive")
+		}
+		rl.callerLimiter = ratelimit.New(conf.WebhookCallerLimit.RequestsPerSecond, conf.WebhookCallerLimit.Burst)
+	}
+	if rl.Enabled() {
+		go func() {
+			for range time.Tick(rateLimitPruneInterval) {
+				rl.prune()
+			}
+		}()
+	}
+	return rl, nil
+}
+
+// Enabled reports whether any requests are limited
+func (rl *RateLimiter) Enabled() bool {
+	return len(rl.rules) != 0 || rl.callerLimiter != nil
+}
+
+// Middleware refuses requests over the limit of their route and client with HTTP 429 and Retry-After.
+// Authenticated kube-apiservers are exempt on the webhook endpoints, or limited per caller with webhookCallerLimit,
+// so that other clients can not use up the limit of the webhook endpoints.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if !rl.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeName := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			routeName = route.GetName()
+		}
+		var limiter *ratelimit.Limiter
+		var identity string
+		if caller, ok := rl.webhookCaller(routeName, r); ok {
+			limiter, identity = rl.callerLimiter, "caller:"+caller
+		} else if rule := rl.rule(routeName); rule != nil {
+			limiter, identity = rule.limiter, rl.identity(rule.key, r)
+		}
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.Allow(routeName + " " + identity); !ok {
+			metrics.RateLimited(routeName)
+			log.WithRequest(r).Debugf("Rate limit of %s exceeded by %s", routeName, identity)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			response.Send(http.StatusTooManyRequests, fmt.Errorf("Rate limit exceeded, retry later"), nil, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rule returns the first rule listing the route, nil if the route is not limited
+func (rl *RateLimiter) rule(routeName string) *rateLimitRule {
+	for i := range rl.rules {
+		if rl.rules[i].routes[routeName] || rl.rules[i].routes["*"] {
+			return &rl.rules[i]
+		}
+	}
+	return nil
+}
+
+// webhookCaller returns the authenticated kube-apiserver of a request to a webhook endpoint
+func (rl *RateLimiter) webhookCaller(routeName string, r *http.Request) (string, bool) {
+	if !rl.webhookRoutes[routeName] || !rl.callers.Enabled() {
+		return "", false
+	}
+	caller, err := rl.callers.identify(r)
+	return caller, err == nil
+}
+
+// identity returns the client of the request for the key. Unverified certificates and invalid tokens are
+// ignored, otherwise a client could get a new bucket with every request
+func (rl *RateLimiter) identity(key string, r *http.Request) string {
+	switch key {
+	case RateLimitKeyCertificate:
+		if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+			return "cn:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+		}
+	case RateLimitKeyToken:
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			if userName, ok := auth.TokenUsername(authHeader); ok {
+				return "user:" + userName
+			}
+		}
+	}
+	return "ip:" + remoteIP(r)
+}
+
+func (rl *RateLimiter) prune() {
+	for _, rule := range rl.rules {
+		rule.limiter.Prune()
+	}
+	if rl.callerLimiter != nil {
+		rl.callerLimiter.Prune()
+	}
+}
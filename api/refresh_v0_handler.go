@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/userstore"
+)
+
+// RefreshV0Handler exchanges a refresh token for a new access token and refresh token.
+// The user is looked up again so that changed groups are in the new access token. The refresh token
+// is redeemed only once the access token is issued, so a failing user store does not use it up.
+func RefreshV0Handler(store userstore.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.RefreshTokensEnabled() {
+			sendResponse(http.StatusNotFound, "", types.RawAuthResponse{}, fmt.Errorf("Refresh tokens are not enabled"), w)
+			return
+		}
+		var request types.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+			sendResponse(http.StatusBadRequest, "", types.RawAuthResponse{}, fmt.Errorf("Need refreshToken in the request body"), w)
+			return
+		}
+		grant, err := auth.CheckRefreshToken(request.RefreshToken)
+		if err != nil {
+			errHandle(w, r, fmt.Sprintf("Unable to refresh token : %s", err), "Invalid refresh token", 401)
+			return
+		}
+		userName := grant.UserName
+		log.SetUsername(r, userName)
+		userDetail, err := store.Lookup(userName)
+		if err == userstore.ErrUserNotFound {
+			// The user is gone, the refresh token can not be used any more
+			auth.RevokeRefreshToken(request.RefreshToken)
+			errHandle(w, r, fmt.Sprintf("User %s not found on refresh", userName), "Invalid refresh token", 401)
+			return
+		}
+		if err != nil {
+			// The user store is not available, the refresh token stays valid for a retry
+			errHandle(w, r, fmt.Sprintf("Unable to look up user %s on refresh : %s", userName, err), "Unable to refresh token, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		// The lifetime asked for on login still caps the refreshed token
+		v1Token, err := newV1Token(userDetail, grant.Groups, grant.ExpiresIn)
+		if err == auth.ErrNoMatchingGroups {
+			// The user lost all groups the login asked for
+			auth.RevokeRefreshToken(request.RefreshToken)
+			errHandle(w, r, fmt.Sprintf("Unable to refresh token of %s : %s", userName, err), "Invalid refresh token", 401)
+			return
+		}
+		if err != nil {
+			errHandle(w, r, fmt.Sprintf("Something is wrong with auth token. : %s", err), "Authentication failed", 401)
+			return
+		}
+		refreshToken, err := auth.RotateRefreshToken(request.RefreshToken)
+		if err != nil {
+			// Redeemed by a concurrent refresh
+			errHandle(w, r, fmt.Sprintf("Unable to refresh token : %s", err), "Invalid refresh token", 401)
+			return
+		}
+		v1Token.RefreshToken = refreshToken.JWT
+		v1Token.RefreshExpiry = refreshToken.Expiry
+
+		data, _ := json.Marshal(v1Token)
+		response := JSONResponse{}
+		response.status = http.StatusOK
+		response.data = data
+		response.Write(w)
+	}
+}
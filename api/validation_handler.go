@@ -4,15 +4,45 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/dinumathai/auth-webhook-sample/audit"
 	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 	"github.com/dinumathai/auth-webhook-sample/util/response"
 )
 
-//ValidationHandler validates the token
-func ValidationHandler(apiVersion auth.Version) http.HandlerFunc {
+// ValidationHandler validates the token. An invalid token is answered with HTTP 200 and a TokenReview with
+// status.authenticated false and status.error set, unless authConfig.legacyErrorResponses is set
+func ValidationHandler(config *types.ConfigMap, apiVersion auth.Version) http.HandlerFunc {
+	legacy := config.AuthConfig.LegacyErrorResponses
 	return func(w http.ResponseWriter, r *http.Request) {
 		userInfo, statusCode, tokenErr := auth.ValidateToken(r, apiVersion)
+		outcome := metrics.ReviewBadRequest
+		switch statusCode {
+		case http.StatusOK:
+			outcome = metrics.ReviewAuthenticated
+		case http.StatusUnauthorized:
+			outcome = metrics.ReviewUnauthenticated
+		}
+		metrics.TokenReview(outcome)
+		record := auditRecord(r, types.AuditTokenReview, outcome)
+		if userInfo.Status != nil && userInfo.Status.User != nil {
+			log.SetUsername(r, userInfo.Status.User.Username)
+			record.User, record.Groups = userInfo.Status.User.Username, userInfo.Status.User.Groups
+		}
+		if tokenErr != nil {
+			record.Reason = tokenErr.Error()
+		}
+		audit.Log(record)
+		if statusCode == http.StatusUnauthorized {
+			if legacy {
+				statusCode = http.StatusBadRequest
+			} else {
+				userInfo.Status.Error = tokenErr.Error()
+				statusCode, tokenErr = http.StatusOK, nil
+			}
+		}
 		sendV1BetaResponse(statusCode, userInfo, tokenErr, w)
 	}
 }
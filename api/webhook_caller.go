@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/response"
+	"github.com/dinumathai/auth-webhook-sample/util/security"
+)
+
+type webhookCallerKey struct{}
+
+// WebhookCallers are the credentials accepted from the kube-apiservers calling the webhook endpoints
+type WebhookCallers struct {
+	// tokens maps the sha256 of a token to its cluster
+	tokens      map[[sha256.Size]byte]string
+	roots       *x509.CertPool
+	clientNames []string
+}
+
+// NewWebhookCallers loads the caller tokens and the client CA of the config. Without either it fails,
+// unless allowUnauthenticated is set
+func NewWebhookCallers(conf types.WebhookCallerConfig) (*WebhookCallers, error) {
+	callers := &WebhookCallers{
+		tokens:      map[[sha256.Size]byte]string{},
+		clientNames: conf.ClientNames,
+	}
+	for i, callerToken := range conf.Tokens {
+		token := callerToken.Token
+		if callerToken.TokenFile != "" {
+			data, err := ioutil.ReadFile(callerToken.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("authConfig.webhookCallers.tokens[%d] has neither token nor tokenFile", i)
+		}
+		cluster := callerToken.Cluster
+		if cluster == "" {
+			cluster = fmt.Sprintf("tokens[%d]", i)
+		}
+		callers.tokens[sha256.Sum256([]byte(token))] = cluster
+	}
+	if conf.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		callers.roots = x509.NewCertPool()
+		if !callers.roots.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("No certificates found in %s", conf.ClientCAFile)
+		}
+	}
+	if !callers.Enabled() {
+		if !conf.AllowUnauthenticated {
+			return nil, fmt.Errorf("authConfig.webhookCallers has neither tokens nor clientCAFile, anyone could call /v0/authenticate and /v0/authorize. " +
+				"Configure the credentials of the kube-apiservers or set authConfig.webhookCallers.allowUnauthenticated")
+		}
+		log.Warnf("Webhook caller authentication is off(authConfig.webhookCallers.allowUnauthenticated), anyone can call /v0/authenticate and /v0/authorize")
+	}
+	return callers, nil
+}
+
+// Enabled reports whether callers have to authenticate
+func (c *WebhookCallers) Enabled() bool {
+	return len(c.tokens) != 0 || c.roots != nil
+}
+
+// RequireWebhookCaller only lets requests of a configured kube-apiserver through. The bearer token of the
+// caller is removed from the request, so the token to validate has to be in the TokenReview body.
+func RequireWebhookCaller(callers *WebhookCallers, next http.HandlerFunc) http.HandlerFunc {
+	if !callers.Enabled() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, err := callers.identify(r)
+		if err != nil {
+			log.WithRequest(r).Warnf("Rejected webhook request %s %s from %s : %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			response.Send(http.StatusUnauthorized, fmt.Errorf("Webhook caller not authenticated"), nil, w)
+			return
+		}
+		log.WithRequest(r).Debugf("Webhook request %s %s from %s", r.Method, r.URL.Path, caller)
+		r.Header.Del("Authorization")
+		next(w, r.WithContext(context.WithValue(r.Context(), webhookCallerKey{}, caller)))
+	}
+}
+
+// WebhookCaller returns the name of the authenticated kube-apiserver that sent the request, empty if none
+func WebhookCaller(r *http.Request) string {
+	caller, _ := r.Context().Value(webhookCallerKey{}).(string)
+	return caller
+}
+
+func (c *WebhookCallers) identify(r *http.Request) (string, error) {
+	var certErr error
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 && c.roots != nil {
+		caller, err := c.verifyCertificate(r.TLS.PeerCertificates)
+		if err == nil {
+			return caller, nil
+		}
+		certErr = err
+	}
+	if authHeader := r.Header.Get("Authorization"); len(c.tokens) != 0 && strings.HasPrefix(authHeader, "Bearer ") {
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(authHeader, "Bearer ")))
+		for known, cluster := range c.tokens {
+			if subtle.ConstantTimeCompare(sum[:], known[:]) == 1 {
+				return "cluster " + cluster, nil
+			}
+		}
+		return "", fmt.Errorf("Unknown caller token")
+	}
+	if certErr != nil {
+		return "", certErr
+	}
+	return "", fmt.Errorf("No caller token or client certificate")
+}
+
+func (c *WebhookCallers) verifyCertificate(chain []*x509.Certificate) (string, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	cert := chain[0]
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Client certificate %q not valid : %v", cert.Subject.CommonName, err)
+	}
+	if len(c.clientNames) == 0 || security.MatchCertificateName(cert, c.clientNames) {
+		return "certificate " + cert.Subject.CommonName, nil
+	}
+	return "", fmt.Errorf("Client certificate %q not in authConfig.webhookCallers.clientNames", cert.Subject.CommonName)
+}
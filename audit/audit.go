@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 10
+
+	// rotatedSuffix is appended to the file name of rotated files, it sorts in the order of rotation
+	rotatedSuffix = "2006-01-02T15-04-05.000"
+)
+
+// GenesisHash is the PrevHash of the first record of a chain
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// hashField separates the record from its hash in a line, the hash is always the last field
+const hashField = `,"hash":"`
+
+// sink appends the records to the audit file. Each record holds the hash of the previous one, so a changed,
+// inserted or deleted line breaks the chain. The chain continues in the new file after a rotation.
+type sink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	seq      uint64
+	prevHash string
+}
+
+var auditSink *sink
+
+// Open starts appending to authConfig.audit.filePath, continuing the chain of the records already in it.
+// Does nothing if the audit log is not configured.
+func Open(conf types.AuditConfig) error {
+	if conf.FilePath == "" {
+		log.Infof("authConfig.audit.filePath not set, audit log disabled")
+		return nil
+	}
+	s := &sink{path: conf.FilePath, maxSize: defaultMaxSizeMB << 20, maxBackups: defaultMaxBackups, prevHash: GenesisHash}
+	if conf.MaxSizeMB > 0 {
+		s.maxSize = int64(conf.MaxSizeMB) << 20
+	}
+	if conf.MaxBackups != 0 {
+		s.maxBackups = conf.MaxBackups
+	}
+	if err := s.resume(); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	log.Infof("Writing audit log to %s, continuing at record %d", s.path, s.seq+1)
+	auditSink = s
+	return nil
+}
+
+// Log appends the record to the audit log. Write failures are logged, the request is not failed
+func Log(record types.AuditRecord) {
+	if auditSink == nil {
+		return
+	}
+	if err := auditSink.write(record); err != nil {
+		log.Errorf("Writing audit record failed : %v", err)
+	}
+}
+
+func (s *sink) write(record types.AuditRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record.Seq = s.seq + 1
+	record.Time = record.Time.UTC()
+	record.PrevHash = s.prevHash
+	record.Hash = ""
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	hash := chainHash(s.prevHash, content)
+	line := append(content[:len(content)-1], []byte(hashField+hash+"\"}\n")...)
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	s.seq, s.prevHash = record.Seq, hash
+	return nil
+}
+
+// resume reads the last record of the file to continue its chain
+func (s *sink) resume() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s.resumeFromBackup()
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := lastRecord(f)
+	if err != nil {
+		return fmt.Errorf("Unable to continue the audit log %s : %v", s.path, err)
+	}
+	if last == nil {
+		return s.resumeFromBackup()
+	}
+	s.seq, s.prevHash = last.Seq, last.Hash
+	return nil
+}
+
+// resumeFromBackup continues the chain of the last rotated file, if the current file is empty
+func (s *sink) resumeFromBackup() error {
+	backups, err := s.backups()
+	if err != nil || len(backups) == 0 {
+		return err
+	}
+	f, err := os.Open(backups[len(backups)-1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := lastRecord(f)
+	if err != nil {
+		return fmt.Errorf("Unable to continue the audit log %s : %v", backups[len(backups)-1], err)
+	}
+	if last != nil {
+		s.seq, s.prevHash = last.Seq, last.Hash
+	}
+	return nil
+}
+
+func (s *sink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the file with the time as suffix, starts a new one and deletes the oldest rotated files
+func (s *sink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	rotated := s.path + "." + time.Now().UTC().Format(rotatedSuffix)
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	log.Infof("Rotated audit log to %s", rotated)
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.maxBackups < 0 {
+		return nil
+	}
+	backups, err := s.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > s.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files, oldest first
+func (s *sink) backups() ([]string, error) {
+	files, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// lastRecord returns the last record of the file, nil if the file is empty
+func lastRecord(r io.Reader) (*types.AuditRecord, error) {
+	var last []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) != 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	var record types.AuditRecord
+	if err := json.Unmarshal(last, &record); err != nil || record.Hash == "" {
+		return nil, fmt.Errorf("last line is not an audit record")
+	}
+	return &record, nil
+}
+
+// chainHash is the hash of a record, content is the JSON of the record without the hash field
+func chainHash(prevHash string, content []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+// VerifyResult - Outcome of a successful verification
+type VerifyResult struct {
+	Records  int
+	FirstSeq uint64
+	LastSeq  uint64
+	// Anchored - The chain starts with the first record ever written, no earlier file is missing
+	Anchored bool
+	LastHash string
+}
+
+// Verify checks the chain of the records in the files, given oldest first. It fails at the first record
+// that was changed, inserted or deleted, or whose hash or sequence does not follow the previous record.
+func Verify(files []string) (VerifyResult, error) {
+	var result VerifyResult
+	for _, file := range files {
+		if err := verifyFile(file, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func verifyFile(file string, result *VerifyResult) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := verifyRecord(line, result); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func verifyRecord(line []byte, result *VerifyResult) error {
+	idx := bytes.LastIndex(line, []byte(hashField))
+	if idx < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return fmt.Errorf("no hash in record")
+	}
+	hash := string(line[idx+len(hashField) : len(line)-2])
+	content := append(append([]byte{}, line[:idx]...), '}')
+
+	var record types.AuditRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return fmt.Errorf("not an audit record : %v", err)
+	}
+	if result.Records == 0 {
+		result.FirstSeq = record.Seq
+		result.Anchored = record.PrevHash == GenesisHash && record.Seq == 1
+	} else {
+		if record.Seq != result.LastSeq+1 {
+			return fmt.Errorf("record %d follows record %d, records are missing or inserted", record.Seq, result.LastSeq)
+		}
+		if record.PrevHash != result.LastHash {
+			return fmt.Errorf("record %d does not chain to record %d", record.Seq, result.LastSeq)
+		}
+	}
+	if chainHash(record.PrevHash, content) != hash || record.Hash != hash {
+		return fmt.Errorf("record %d was modified, its hash does not match", record.Seq)
+	}
+	result.Records++
+	result.LastSeq = record.Seq
+	result.LastHash = hash
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dinumathai/auth-webhook-sample/audit"
+)
+
+// auditVerify implements the audit-verify command. It verifies the hash chain of the audit files given
+// oldest first, e.g. audit-verify audit.log.* audit.log
+func auditVerify(args []string) int {
+	flags := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s audit-verify file...\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "The files must be given oldest first, rotated files sort by name e.g. audit.log.* audit.log\n")
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	result, err := audit.Verify(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log verification failed after %d records : %v\n", result.Records, err)
+		return 1
+	}
+	if result.Records == 0 {
+		fmt.Println("No audit records found")
+		return 0
+	}
+	fmt.Printf("OK: %d records, %d to %d, last hash %s\n", result.Records, result.FirstSeq, result.LastSeq, result.LastHash)
+	if !result.Anchored {
+		fmt.Printf("The chain starts at record %d, the files with the earlier records were not given or were deleted\n", result.FirstSeq)
+	}
+	return 0
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"os"
 
+	"github.com/dinumathai/auth-webhook-sample/audit"
+	"github.com/dinumathai/auth-webhook-sample/auth"
 	cfg "github.com/dinumathai/auth-webhook-sample/config"
 	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/server"
@@ -15,15 +18,41 @@ var addr = flag.String("listen-address", ":8443", "The address to listen on for
 var Version = "notSet"
 
 func main() {
+	// Sub commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash-password":
+			os.Exit(hashPassword(os.Args[2:]))
+		case "audit-verify":
+			os.Exit(auditVerify(os.Args[2:]))
+		}
+	}
+
 	//Get config
 	config, err := cfg.Load()
 	if err != nil || config.AuthConfig.ServerAddress == 0 {
 		log.Fatalf("Config not loaded correctly - %v", err)
 	}
 
+	if err := auth.LoadKeys(config.AuthConfig); err != nil {
+		log.Fatalf("Signing keys not loaded correctly - %v", err)
+	}
+	auth.WatchKeys(config.AuthConfig)
+	if err := auth.LoadRevocations(config.AuthConfig); err != nil {
+		log.Fatalf("Revoked tokens not loaded correctly - %v", err)
+	}
+	if err := auth.ConfigureLoginLockout(config.AuthConfig); err != nil {
+		log.Fatalf("Login lockout not configured correctly - %v", err)
+	}
+	if err := audit.Open(config.AuthConfig.Audit); err != nil {
+		log.Fatalf("Audit log not opened correctly - %v", err)
+	}
+
 	//server
 	log.Info("Starting Auth server..........")
 	health.Version = Version
 	// server.Start(userStore, config) //Commeting out local cache for now.
-	server.Start(config)
+	if err := server.Start(config); err != nil {
+		log.Fatalf("%v", err)
+	}
 }
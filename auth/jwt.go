@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -27,30 +27,52 @@ const (
 	V1 = 1
 	V2 = 2
 
-	APIVerString = "authentication.k8s.io/v2"
+	// TokenReview api versions. Responses echo the version of the request
+	APIVerString        = "authentication.k8s.io/v1"
+	APIVerV1Beta1String = "authentication.k8s.io/v1beta1"
 )
 
 // Version -- constrained type
 type Version int
 
-//GenerateToken generates a full JWT groups and apps etc.
-func GenerateToken(user types.User, hclaims string, majVersion Version) (types.Token, error) {
+// GenerateToken generates a full JWT groups and apps etc. The token expires after ttl, see TokenTTL
+func GenerateToken(user types.User, hclaims string, majVersion Version, ttl time.Duration) (types.Token, error) {
 
 	//Create the token
-	token := jwt.New(jwt.SigningMethodHS256)
+	kid, method, signingKey := keys.signingMaterial()
+	token := jwt.New(method)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
 
 	// Create a map to store our claims
 	claims := token.Claims.(jwt.MapClaims)
 
+	id, err := randomString()
+	if err != nil {
+		return types.Token{}, err
+	}
+
 	// Set claims
+	claims["jti"] = id
 	claims["username"] = user.Username
 	claims["uid"] = user.UID
-	//	filteredGroups := FilterGroupsOnClaims(user.Groups, hclaims)
-	claims["groups"] = user.Groups
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	claims["sub"] = user.UID
+	if issuer := config.AppConfig.AuthConfig.Issuer; issuer != "" {
+		claims["iss"] = issuer
+	}
+	filteredGroups, err := FilterGroupsOnClaims(user.Groups, hclaims)
+	if err != nil {
+		return types.Token{}, err
+	}
+	claims["groups"] = filteredGroups
+	if audiences := config.AppConfig.AuthConfig.TokenAudiences; len(audiences) != 0 {
+		claims["aud"] = audiences
+	}
+	claims["exp"] = time.Now().Add(ttl).Unix()
 	claims["iat"] = time.Now().Unix()
 
-	signedToken, err := token.SignedString([]byte(config.AppConfig.AuthConfig.AuthSigningKey))
+	signedToken, err := token.SignedString(signingKey)
 	if err != nil {
 		log.Errorf("Cannot sign token  : %s", err)
 		return types.Token{}, err
@@ -63,13 +85,14 @@ func GenerateToken(user types.User, hclaims string, majVersion Version) (types.T
 
 }
 
-//ValidateToken validates JWT token provided by user and fills out the UserInfo structure from the data within
+// ValidateToken validates JWT token provided by user and fills out the UserInfo structure from the data within.
+// The status code is http.StatusBadRequest if the request has no token and http.StatusUnauthorized if the token is not valid
 func ValidateToken(req *http.Request, apiVersion Version) (types.UserInfo, int, error) {
 	auth := new(bool)
 	*auth = false
 
 	errUserInfo, errBadReq := types.UserInfo{
-		APIVersion: APIVerString,
+		APIVersion: APIVerV1Beta1String,
 		Kind:       "TokenReview",
 		Status: &types.Status{
 			Authenticated: auth,
@@ -78,64 +101,73 @@ func ValidateToken(req *http.Request, apiVersion Version) (types.UserInfo, int,
 	}, fmt.Errorf("Either need valid JWT bearer token in Authorization header or need valid kubernetes webhook auth request (Please refer - %s)", "https://kubernetes.io/docs/reference/access-authn-authz/authentication/#webhook-token-authentication")
 
 	//If body is empty or not able to parse properly then try with Auth header
-	log.Debugf("Request headers : %v", req.Header)
-	request, err := getRequestBody(req.Body)
+	log.WithRequest(req).Debugf("Request headers : %v", log.RedactHeaders(req.Header))
+	request, err := getRequestBody(req)
 	if err != nil {
-		log.Debugf("Unable to parse request body: %v. Trying with Authorization header.", err)
+		log.WithRequest(req).Debugf("Unable to parse request body: %v. Trying with Authorization header.", err)
 
 		token, err := checkAuthScheme(req.Header.Get("Authorization"))
 		if err != nil {
 			return errUserInfo, http.StatusBadRequest, errBadReq
 		}
 
-		return validate(token, apiVersion) // note: most work happens here <<<
+		return validate(token, APIVerV1Beta1String, nil) // note: most work happens here <<<
 	}
 
-	log.Debug("Received auth token from body. Skipping Auth Header check.")
+	log.WithRequest(req).Debugf("Received auth token from body. Skipping Auth Header check.")
+	reviewVersion := reviewAPIVersion(request.APIVersion)
+	errUserInfo.APIVersion = reviewVersion
 	//Get Auth token from body and validate
-	if request.Spec.Token != "" {
-		return validate(request.Spec.Token, apiVersion) // note: most work happens here <<<
+	if request.Spec != nil && request.Spec.Token != "" {
+		return validate(request.Spec.Token, reviewVersion, request.Spec.Audiences) // note: most work happens here <<<
 	}
 	return errUserInfo, http.StatusBadRequest, errBadReq
 }
 
-func getRequestBody(body io.ReadCloser) (types.Request, error) {
-	content, err := ioutil.ReadAll(body)
+func getRequestBody(req *http.Request) (types.Request, error) {
+	logger := log.WithRequest(req)
+	content, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		log.Debugf("Error in Read of request body : %s", err)
+		logger.Debugf("Error in Read of request body : %s", err)
 		return types.Request{}, err
 	}
 	rawContent := json.RawMessage(string(content))
-	log.Debugf("Request body : %s", rawContent)
+	logger.Debugf("Request body : %s", rawContent)
 	marshaledContent, err := rawContent.MarshalJSON()
 	if err != nil {
-		log.Debugf("Error in marshaling request body : %s", err)
-		log.Debugf("Request Body might be empty. If so we will try with Authorization Header")
+		logger.Debugf("Error in marshaling request body : %s", err)
+		logger.Debugf("Request Body might be empty. If so we will try with Authorization Header")
 		return types.Request{}, err
 	}
 
 	var request types.Request
 	err = json.Unmarshal(marshaledContent, &request)
 	if err != nil {
-		log.Debugf("Error in un-marshaling request body : %s", err)
-		log.Debugf("Request Body might be empty or not of kube webhook auth request type. If so we will try with Authorization Header")
+		logger.Debugf("Error in un-marshaling request body : %s", err)
+		logger.Debugf("Request Body might be empty or not of kube webhook auth request type. If so we will try with Authorization Header")
 		return types.Request{}, err
 	}
 
 	return request, nil
 }
 
-// validate does much of the work of ValidateToken
-func validate(bearerToken string, apiVersion Version) (types.UserInfo, int, error) {
+// reviewAPIVersion returns the api version of the TokenReview response for the version of the request
+func reviewAPIVersion(requestVersion string) string {
+	if requestVersion == APIVerV1Beta1String {
+		return APIVerV1Beta1String
+	}
+	return APIVerString
+}
 
-	signingKey := config.AppConfig.AuthConfig.AuthSigningKey
+// validate does much of the work of ValidateToken. If audiences are given the token must be valid for one of them.
+// Invalid tokens are reported with http.StatusUnauthorized
+func validate(bearerToken string, reviewVersion string, audiences []string) (types.UserInfo, int, error) {
 
 	var auth bool
-	var claims types.JWTClaimsJSON // special struct for decoding the json
 
 	// user we'll return, initially in error state
 	u := types.UserInfo{
-		APIVersion: "authentication.k8s.io/v1beta1",
+		APIVersion: reviewVersion,
 		Kind:       "TokenReview",
 		Status: &types.Status{
 			Authenticated: &auth,
@@ -143,26 +175,21 @@ func validate(bearerToken string, apiVersion Version) (types.UserInfo, int, erro
 		},
 	}
 
-	token, err := jwt.ParseWithClaims(bearerToken, &claims, func(token *jwt.Token) (interface{}, error) {
-		if !strings.HasPrefix(token.Method.Alg(), "HS") { // HMAC are the only allowed signing methods
-			log.Errorf("Unexpected signing method: %s", token.Method.Alg())
-			return nil, fmt.Errorf("Unexpected signing method: %s", token.Method.Alg())
-		}
-		return []byte(signingKey), nil
-	})
+	claims, err := parseToken(bearerToken)
 	if err != nil {
-		log.Errorf("Error Parsing JWT. Error - %v", err)
-		return u, http.StatusBadRequest, err
+		return u, http.StatusUnauthorized, err
 	}
 
-	if !token.Valid {
-		log.Errorf("Token not valid: %v", err)
-		return u, http.StatusBadRequest, err
+	tokenAudiences, err := matchAudiences(claims.Audience, audiences)
+	if err != nil {
+		log.Errorf("Token audience check failed: %v", err)
+		return u, http.StatusUnauthorized, err
 	}
 
 	// Token is valid so fill in the rest of u with happy state and return it
 	auth = true
 	u.Status.Authenticated = &auth
+	u.Status.Audiences = tokenAudiences
 	u.Status.User = &types.User{
 		Username: claims.Username,
 		UID:      claims.UID,
@@ -172,6 +199,99 @@ func validate(bearerToken string, apiVersion Version) (types.UserInfo, int, erro
 
 }
 
+// parseToken checks the signature, expiry, issuer and revocation of the token and returns its claims
+func parseToken(bearerToken string) (types.JWTClaimsJSON, error) {
+	return checkToken(bearerToken, log.Errorf)
+}
+
+// TokenUsername returns the username of a valid bearer token in the Authorization header. Rejected tokens
+// are only logged at debug level, for callers like the rate limiter that see invalid tokens in numbers
+func TokenUsername(authHeader string) (string, bool) {
+	token, err := checkAuthScheme(authHeader)
+	if err != nil {
+		return "", false
+	}
+	claims, err := checkToken(token, log.Debugf)
+	if err != nil {
+		return "", false
+	}
+	return claims.Username, true
+}
+
+// checkToken does the checks of parseToken, logging why a token is rejected with logf
+func checkToken(bearerToken string, logf func(format string, args ...interface{})) (types.JWTClaimsJSON, error) {
+	var claims types.JWTClaimsJSON // special struct for decoding the json
+
+	token, err := jwt.ParseWithClaims(bearerToken, &claims, keys.verificationKey)
+	if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		// Tokens signed with the replaced authSigningKey are accepted until it is retired
+		if previous, previousErr := jwt.ParseWithClaims(bearerToken, &claims, keys.previousLegacyKey); previousErr == nil {
+			token, err = previous, nil
+		}
+	}
+	if err != nil {
+		logf("Error Parsing JWT. Error - %v", err)
+		return claims, err
+	}
+
+	if !token.Valid {
+		logf("Token not valid: %v", err)
+		return claims, errors.New("Token not valid")
+	}
+
+	// Tokens issued before an issuer was configured do not carry the claim
+	if issuer := config.AppConfig.AuthConfig.Issuer; claims.Issuer != "" && claims.Issuer != issuer {
+		logf("Token issued by unknown issuer: %s", claims.Issuer)
+		return claims, fmt.Errorf("Token issued by unknown issuer %s", claims.Issuer)
+	}
+
+	if revocations.revoked(claims) {
+		logf("Revoked token %q of %s used", claims.ID, claims.Username)
+		return claims, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// matchAudiences returns the audiences of the review the token is valid for. Tokens without an aud
+// claim are rejected when the review has audiences, unless allowTokenWithoutAudience is set.
+func matchAudiences(tokenAudiences types.ClaimStrings, reviewAudiences []string) ([]string, error) {
+	if len(reviewAudiences) == 0 {
+		return nil, nil
+	}
+	if len(tokenAudiences) == 0 {
+		if config.AppConfig.AuthConfig.AllowTokenWithoutAudience {
+			return reviewAudiences, nil
+		}
+		return nil, fmt.Errorf("Token has no audience, expected one of %v", reviewAudiences)
+	}
+	var matched []string
+	for _, audience := range reviewAudiences {
+		for _, tokenAudience := range tokenAudiences {
+			if audience == tokenAudience {
+				matched = append(matched, audience)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("Token audiences %v do not match any of %v", []string(tokenAudiences), reviewAudiences)
+	}
+	return matched, nil
+}
+
+// ValidateAuthorizationHeader validates the bearer token in the Authorization header and returns its user
+func ValidateAuthorizationHeader(authHeader string) (*types.User, error) {
+	token, err := checkAuthScheme(authHeader)
+	if err != nil {
+		return nil, err
+	}
+	userInfo, _, err := validate(token, APIVerString, nil)
+	if err != nil {
+		return nil, err
+	}
+	return userInfo.Status.User, nil
+}
+
 func checkAuthScheme(authHeader string) (string, error) {
 	if authHeader == "" {
 		return "", errors.New("Didn't receive any auth token")
@@ -187,12 +307,30 @@ func checkAuthScheme(authHeader string) (string, error) {
 	return authHeader[len(BearerSchema):], nil
 }
 
-// FilterGroupsOnClaims returns groups that match at least one claim
-func FilterGroupsOnClaims(groups []string, claims string) []string {
+// ParseGroupClaims returns the group patterns requested for a token as comma separated list. groups is
+// comma separated, scope space separated as in OAuth. Both can be empty.
+func ParseGroupClaims(groups string, scope string) (string, error) {
+	var patterns []string
+	for _, pattern := range append(strings.Split(groups, ","), strings.Fields(scope)...) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("Invalid group pattern %q", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return strings.Join(patterns, ","), nil
+}
+
+// FilterGroupsOnClaims returns groups that match at least one claim. Claims are comma separated patterns,
+// either a group name, a prefix ending with * or a glob as in path.Match. Fails if no group matches.
+func FilterGroupsOnClaims(groups []string, claims string) ([]string, error) {
 
 	c2 := strings.TrimSpace(claims)
 	if len(c2) == 0 { // no claims -> everything
-		return groups
+		return groups, nil
 	}
 
 	clist := strings.Split(c2, ",")
@@ -202,13 +340,32 @@ grouploop:
 	for _, g := range groups {
 		for _, c := range clist {
 			cmi := strings.TrimSpace(c)
-			if len(cmi) > 0 && strings.Contains(g, cmi) {
+			if len(cmi) > 0 && matchGroup(g, cmi) {
 				gs = append(gs, g)
 				continue grouploop
 			}
 		}
 	}
 
-	return gs
+	if len(gs) == 0 {
+		return nil, ErrNoMatchingGroups
+	}
+	return gs, nil
+
+}
+
+// ErrNoMatchingGroups is returned when none of the groups of the user match the requested groups
+var ErrNoMatchingGroups = errors.New("None of the groups of the user match the requested groups")
 
+func matchGroup(group string, pattern string) bool {
+	prefix := strings.TrimSuffix(pattern, "*")
+	if !strings.ContainsAny(prefix, `*?[\`) {
+		if prefix != pattern {
+			// Trailing * matches any suffix, including a /
+			return strings.HasPrefix(group, prefix)
+		}
+		return group == pattern
+	}
+	matched, _ := path.Match(pattern, group)
+	return matched
 }
@@ -0,0 +1,474 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/config"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	defaultKeyRetirementGrace = 24 * time.Hour
+	defaultKeyReloadInterval  = time.Minute
+)
+
+// signingKey is a loaded key. private is nil for verification only keys
+type signingKey struct {
+	id       string
+	method   jwt.SigningMethod
+	private  interface{}
+	public   interface{}
+	retireAt time.Time
+}
+
+func (k *signingKey) retired(now time.Time) bool {
+	return !k.retireAt.IsZero() && now.After(k.retireAt)
+}
+
+// keyRing holds the keys used to sign and verify tokens
+type keyRing struct {
+	sync.RWMutex
+	keys   map[string]*signingKey
+	active *signingKey
+	// legacy is the shared HMAC secret(authSigningKey). Used for tokens without a kid header
+	legacy []byte
+	// previousLegacy is the secret legacy replaced, accepted until previousLegacyRetireAt
+	previousLegacy         []byte
+	previousLegacyRetireAt time.Time
+	lastReload             time.Time
+	lastError              string
+}
+
+var keys = &keyRing{}
+
+// LoadKeys loads the signing and verification keys from the auth config.
+// Keys that are removed from the config, and the replaced authSigningKey, are still accepted for
+// verification for keyRetirementGrace so that tokens signed with them keep working until they expire.
+func LoadKeys(authConfig types.AuthConfig) error {
+	loaded, active, err := buildKeys(authConfig)
+
+	keys.Lock()
+	defer keys.Unlock()
+	if err != nil {
+		keys.lastError = err.Error()
+		return err
+	}
+	now := time.Now()
+	retireAt := now.Add(parseDuration(authConfig.KeyRetirementGrace, defaultKeyRetirementGrace))
+	for id, old := range keys.keys {
+		if _, ok := loaded[id]; ok || old.retired(now) {
+			continue
+		}
+		retiring := *old
+		retiring.private = nil
+		if retiring.retireAt.IsZero() || retiring.retireAt.After(retireAt) {
+			retiring.retireAt = retireAt
+			log.Infof("Signing key %s removed from config, accepting it until %s", id, retireAt.Format(time.RFC3339))
+		}
+		loaded[id] = &retiring
+	}
+
+	legacy := []byte(authConfig.AuthSigningKey)
+	if len(authConfig.AuthSigningKey) == 0 {
+		legacy = nil
+	}
+	if keys.legacy != nil && string(keys.legacy) != string(legacy) {
+		log.Infof("authSigningKey changed, accepting the previous key until %s", retireAt.Format(time.RFC3339))
+		keys.previousLegacy = keys.legacy
+		keys.previousLegacyRetireAt = retireAt
+	}
+
+	keys.keys = loaded
+	keys.active = active
+	keys.legacy = legacy
+	keys.lastReload = now
+	keys.lastError = ""
+	log.Infof("Loaded %d signing keys, active key : %q", len(loaded), authConfig.ActiveKeyID)
+	return nil
+}
+
+// ReloadKeys reads the config file again and loads the keys from it. The current keys are kept on failure
+func ReloadKeys() error {
+	newConfig, err := config.Reload()
+	if err != nil {
+		keys.Lock()
+		keys.lastError = err.Error()
+		keys.Unlock()
+		return err
+	}
+	return LoadKeys(newConfig.AuthConfig)
+}
+
+// WatchKeys reloads the keys whenever the config file or one of the key files changes
+func WatchKeys(authConfig types.AuthConfig) {
+	interval := parseDuration(authConfig.KeyReloadInterval, defaultKeyReloadInterval)
+	if interval <= 0 {
+		log.Infof("Signing key reload disabled")
+		return
+	}
+	go func() {
+		current := authConfig
+		lastChange := keyFilesModTime(current)
+		for range time.Tick(interval) {
+			modTime := keyFilesModTime(current)
+			if !modTime.After(lastChange) {
+				continue
+			}
+			lastChange = modTime
+			log.Infof("Signing key configuration changed, reloading")
+			newConfig, err := config.Reload()
+			if err == nil {
+				err = LoadKeys(newConfig.AuthConfig)
+			}
+			if err != nil {
+				keys.Lock()
+				keys.lastError = err.Error()
+				keys.Unlock()
+				log.Errorf("Reloading signing keys failed, keeping the current keys : %v", err)
+				continue
+			}
+			current = newConfig.AuthConfig
+		}
+	}()
+}
+
+// KeyStatus returns the keys currently in use
+func KeyStatus() types.KeyRingStatus {
+	keys.RLock()
+	defer keys.RUnlock()
+	status := types.KeyRingStatus{
+		Keys:       []types.KeyStatus{},
+		LastReload: keys.lastReload,
+		LastError:  keys.lastError,
+	}
+	if keys.active != nil {
+		status.ActiveKeyID = keys.active.id
+	}
+	now := time.Now()
+	for _, key := range keys.keys {
+		if key.retired(now) {
+			continue
+		}
+		keyStatus := types.KeyStatus{
+			KeyID:     key.id,
+			Algorithm: key.method.Alg(),
+			CanSign:   key.private != nil,
+		}
+		if !key.retireAt.IsZero() {
+			retireAt := key.retireAt
+			keyStatus.RetireAt = &retireAt
+		}
+		status.Keys = append(status.Keys, keyStatus)
+	}
+	sort.Slice(status.Keys, func(i, j int) bool { return status.Keys[i].KeyID < status.Keys[j].KeyID })
+	return status
+}
+
+// keyFilesModTime returns the latest modification time of the config file and the key files
+func keyFilesModTime(authConfig types.AuthConfig) time.Time {
+	latest := config.ModTime(config.FilePath())
+	for _, keyConf := range authConfig.SigningKeys {
+		for _, path := range []string{keyConf.PrivateKeyFile, keyConf.PublicKeyFile} {
+			if modTime := config.ModTime(path); path != "" && modTime.After(latest) {
+				latest = modTime
+			}
+		}
+	}
+	return latest
+}
+
+func buildKeys(authConfig types.AuthConfig) (map[string]*signingKey, *signingKey, error) {
+	loaded := make(map[string]*signingKey)
+	now := time.Now()
+	for _, keyConf := range authConfig.SigningKeys {
+		if keyConf.KeyID == "" {
+			return nil, nil, errors.New("keyID is mandatory for signing keys")
+		}
+		if _, ok := loaded[keyConf.KeyID]; ok {
+			return nil, nil, fmt.Errorf("Duplicate signing key %s", keyConf.KeyID)
+		}
+		key, err := loadKey(keyConf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to load signing key %s : %v", keyConf.KeyID, err)
+		}
+		if key.retired(now) {
+			log.Infof("Signing key %s retired at %s, skipping it", key.id, key.retireAt.Format(time.RFC3339))
+			continue
+		}
+		loaded[keyConf.KeyID] = key
+	}
+
+	var active *signingKey
+	if authConfig.ActiveKeyID != "" {
+		active = loaded[authConfig.ActiveKeyID]
+		if active == nil {
+			return nil, nil, fmt.Errorf("Active key %s is not one of the signing keys", authConfig.ActiveKeyID)
+		}
+		if active.private == nil {
+			return nil, nil, fmt.Errorf("Active key %s has no private key or secret", authConfig.ActiveKeyID)
+		}
+		if !active.retireAt.IsZero() {
+			return nil, nil, fmt.Errorf("Active key %s has a retireAt time", authConfig.ActiveKeyID)
+		}
+	} else if authConfig.AuthSigningKey == "" {
+		return nil, nil, errors.New("Either activeKeyID or authSigningKey must be configured")
+	}
+	return loaded, active, nil
+}
+
+// signingMaterial returns the kid(empty for the legacy HMAC secret), method and key used to sign new tokens
+func (kr *keyRing) signingMaterial() (string, jwt.SigningMethod, interface{}) {
+	kr.RLock()
+	defer kr.RUnlock()
+	if kr.active != nil {
+		return kr.active.id, kr.active.method, kr.active.private
+	}
+	return "", jwt.SigningMethodHS256, kr.legacy
+}
+
+// verificationKey selects the key for a token using its kid header
+func (kr *keyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || kr.legacy == nil {
+			return nil, fmt.Errorf("Unexpected signing method: %s", token.Method.Alg())
+		}
+		return kr.legacy, nil
+	}
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %s", kid)
+	}
+	if key.retired(time.Now()) {
+		return nil, fmt.Errorf("Signing key %s is retired", kid)
+	}
+	// The algorithm is pinned by the key, never taken from the token alone
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+// previousLegacyKey is the verification key for tokens signed with the replaced authSigningKey
+func (kr *keyRing) previousLegacyKey(token *jwt.Token) (interface{}, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || kid != "" || !kr.hasPreviousLegacy() {
+		return nil, fmt.Errorf("Unexpected signing method: %s", token.Method.Alg())
+	}
+	return kr.previousLegacy, nil
+}
+
+func (kr *keyRing) hasPreviousLegacy() bool {
+	return kr.previousLegacy != nil && time.Now().Before(kr.previousLegacyRetireAt)
+}
+
+// PublicKeys returns the public keys of the key ring as a JSON Web Key Set.
+// HMAC secrets are never published.
+func PublicKeys() types.JWKS {
+	keys.RLock()
+	defer keys.RUnlock()
+	jwks := types.JWKS{Keys: []types.JWK{}}
+	now := time.Now()
+	for _, key := range keys.keys {
+		if _, ok := key.method.(*jwt.SigningMethodHMAC); ok || key.retired(now) {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, toJWK(key))
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+// SigningAlgorithms returns the algorithms of the keys published in the JWKS. Tokens signed with
+// the shared HMAC secrets can not be verified by others and are left out
+func SigningAlgorithms() []string {
+	keys.RLock()
+	defer keys.RUnlock()
+	algs := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys.keys {
+		if _, hmac := key.method.(*jwt.SigningMethodHMAC); !hmac && !seen[key.method.Alg()] {
+			algs = append(algs, key.method.Alg())
+			seen[key.method.Alg()] = true
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+func toJWK(key *signingKey) types.JWK {
+	jwk := types.JWK{
+		KeyID:     key.id,
+		Use:       "sig",
+		Algorithm: key.method.Alg(),
+	}
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(public.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(public.Y.Bytes(), size))
+	}
+	return jwk
+}
+
+// padBytes left pads the coordinate to the curve size as required by RFC 7518
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func loadKey(keyConf types.SigningKey) (*signingKey, error) {
+	method := jwt.GetSigningMethod(keyConf.Algorithm)
+	key := &signingKey{id: keyConf.KeyID, method: method}
+	if keyConf.RetireAt != "" {
+		retireAt, err := time.Parse(time.RFC3339, keyConf.RetireAt)
+		if err != nil {
+			return nil, fmt.Errorf("retireAt must be a RFC3339 time : %v", err)
+		}
+		key.retireAt = retireAt
+	}
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if keyConf.Secret == "" {
+			return nil, fmt.Errorf("%s needs a secret", method.Alg())
+		}
+		key.private = []byte(keyConf.Secret)
+		key.public = key.private
+		return key, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("Unsupported algorithm %q, expected one of HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512", keyConf.Algorithm)
+	}
+
+	if keyConf.PrivateKeyFile != "" {
+		data, err := ioutil.ReadFile(keyConf.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key.private, err = parsePrivateKey(data); err != nil {
+			return nil, err
+		}
+		switch private := key.private.(type) {
+		case *rsa.PrivateKey:
+			key.public = &private.PublicKey
+		case *ecdsa.PrivateKey:
+			key.public = &private.PublicKey
+		}
+	}
+	if keyConf.PublicKeyFile != "" {
+		data, err := ioutil.ReadFile(keyConf.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		// Tokens signed with a private key not matching the published public key would fail validation
+		if key.public != nil {
+			if matcher, ok := key.public.(interface{ Equal(crypto.PublicKey) bool }); !ok || !matcher.Equal(public) {
+				return nil, fmt.Errorf("The public key in %s is not the public key of %s", keyConf.PublicKeyFile, keyConf.PrivateKeyFile)
+			}
+		}
+		key.public = public
+	}
+	if key.public == nil {
+		return nil, errors.New("Either privateKeyFile or publicKeyFile must be configured")
+	}
+	return key, checkKeyType(method, key.public)
+}
+
+// checkKeyType makes sure the key can be used with the algorithm configured for it
+func checkKeyType(method jwt.SigningMethod, public interface{}) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodECDSA:
+		ecKey, ok := public.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an ECDSA key", m.Alg())
+		}
+		if ecKey.Curve.Params().BitSize != m.CurveBits {
+			return fmt.Errorf("%s needs a P-%d key", m.Alg(), m.CurveBits)
+		}
+	default:
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return fmt.Errorf("%s needs an RSA key", method.Alg())
+		}
+	}
+	return nil
+}
+
+func parsePrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("Private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("Private key must be a PKCS1, SEC1 or PKCS8 RSA/ECDSA key")
+	}
+	return key, nil
+}
+
+func parsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("Public key is not PEM encoded")
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("Public key must be a PKIX or PKCS1 key or a certificate")
+	}
+	return key, nil
+}
+
+// parseDuration parses a duration from the config, returning fallback if it is not set or invalid
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Errorf("Invalid duration %q, using %s : %v", value, fallback, err)
+		return fallback
+	}
+	return duration
+}
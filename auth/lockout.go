@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+const (
+	defaultMaxLoginFailures    = 5
+	defaultMaxSourceIPFailures = 20
+	defaultLoginBaseDelay      = time.Second
+	defaultLoginMaxDelay       = time.Minute
+	defaultLockoutDuration     = 15 * time.Minute
+	defaultFailureWindow       = 15 * time.Minute
+
+	lockoutPruneInterval = time.Minute
+)
+
+// loginFailures is the failure count of a username or source IP
+type loginFailures struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	lockedOut    bool
+}
+
+// loginLockout counts the failed logins per username and per source IP. A source IP, possibly shared by many
+// users behind a NAT, gets maxFailures failures before its backoff starts. Success clears the username only,
+// otherwise an attacker with one valid account could reset the count of the source IP.
+type loginLockout struct {
+	sync.Mutex
+	enabled         bool
+	maxFailures     int
+	maxIPFailures   int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	lockoutDuration time.Duration
+	failureWindow   time.Duration
+	users           map[string]*loginFailures
+	sourceIPs       map[string]*loginFailures
+}
+
+var lockouts = &loginLockout{
+	users:     map[string]*loginFailures{},
+	sourceIPs: map[string]*loginFailures{},
+}
+
+// ConfigureLoginLockout applies authConfig.loginLockout and prunes the failure counts periodically
+func ConfigureLoginLockout(authConfig types.AuthConfig) error {
+	conf := authConfig.LoginLockout
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	if conf.Disabled {
+		log.Infof("authConfig.loginLockout.disabled set, failed logins are not limited")
+		lockouts.enabled = false
+		return nil
+	}
+	lockouts.enabled = true
+	lockouts.maxFailures, lockouts.maxIPFailures = defaultMaxLoginFailures, defaultMaxSourceIPFailures
+	if conf.MaxFailures > 0 {
+		lockouts.maxFailures = conf.MaxFailures
+	}
+	if conf.MaxSourceIPFailures > 0 {
+		lockouts.maxIPFailures = conf.MaxSourceIPFailures
+	}
+	durations := []struct {
+		name     string
+		value    string
+		fallback time.Duration
+		target   *time.Duration
+	}{
+		{"baseDelay", conf.BaseDelay, defaultLoginBaseDelay, &lockouts.baseDelay},
+		{"maxDelay", conf.MaxDelay, defaultLoginMaxDelay, &lockouts.maxDelay},
+		{"lockoutDuration", conf.LockoutDuration, defaultLockoutDuration, &lockouts.lockoutDuration},
+		{"failureWindow", conf.FailureWindow, defaultFailureWindow, &lockouts.failureWindow},
+	}
+	for _, duration := range durations {
+		*duration.target = duration.fallback
+		if duration.value != "" {
+			value, err := time.ParseDuration(duration.value)
+			if err != nil {
+				return fmt.Errorf("Invalid authConfig.loginLockout.%s : %v", duration.name, err)
+			}
+			*duration.target = value
+		}
+	}
+	go func() {
+		for range time.Tick(lockoutPruneInterval) {
+			lockouts.prune()
+		}
+	}()
+	return nil
+}
+
+// LoginBlocked returns how long logins of the username or from the source IP are refused, 0 if they are allowed
+func LoginBlocked(userName, sourceIP string) time.Duration {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	if !lockouts.enabled {
+		return 0
+	}
+	now := time.Now()
+	var wait time.Duration
+	for _, entry := range []*loginFailures{lockouts.users[userName], lockouts.sourceIPs[sourceIP]} {
+		if entry != nil && entry.blockedUntil.Sub(now) > wait {
+			wait = entry.blockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// LoginFailed counts a failed login of the username from the source IP
+func LoginFailed(userName, sourceIP string) {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	if !lockouts.enabled {
+		return
+	}
+	now := time.Now()
+	if lockouts.fail(lockouts.users, userName, 0, lockouts.maxFailures, now) {
+		log.Warnf("User %s locked out for %s after %d failed logins", userName, lockouts.lockoutDuration, lockouts.maxFailures)
+	}
+	if lockouts.fail(lockouts.sourceIPs, sourceIP, lockouts.maxFailures, lockouts.maxIPFailures, now) {
+		log.Warnf("Source IP %s locked out for %s after %d failed logins", sourceIP, lockouts.lockoutDuration, lockouts.maxIPFailures)
+	}
+}
+
+// LoginSucceeded clears the failures of the username
+func LoginSucceeded(userName string) {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	delete(lockouts.users, userName)
+}
+
+// LoginLockouts lists the usernames and source IPs with failed logins
+func LoginLockouts() []types.LoginLockout {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	list := []types.LoginLockout{}
+	for userName, entry := range lockouts.users {
+		list = append(list, entry.status(types.LoginLockout{Username: userName}))
+	}
+	for sourceIP, entry := range lockouts.sourceIPs {
+		list = append(list, entry.status(types.LoginLockout{SourceIP: sourceIP}))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastFailure.After(list[j].LastFailure) })
+	return list
+}
+
+// ClearLoginLockout forgets the failures of the username and the source IP, of all if all is set
+func ClearLoginLockout(userName, sourceIP string, all bool) {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	if all {
+		lockouts.users = map[string]*loginFailures{}
+		lockouts.sourceIPs = map[string]*loginFailures{}
+		log.Infof("Cleared all login lockouts")
+		return
+	}
+	if userName != "" {
+		delete(lockouts.users, userName)
+		log.Infof("Cleared login lockout of user %s", userName)
+	}
+	if sourceIP != "" {
+		delete(lockouts.sourceIPs, sourceIP)
+		log.Infof("Cleared login lockout of source IP %s", sourceIP)
+	}
+}
+
+// fail counts a failure of the key and blocks it for the backoff delay once there are more than free failures,
+// for the lockout duration once max failures are reached. Returns true if the key got locked out
+func (l *loginLockout) fail(entries map[string]*loginFailures, key string, free int, max int, now time.Time) bool {
+	entry := entries[key]
+	if entry == nil || now.Sub(entry.lastFailure) > l.failureWindow && now.After(entry.blockedUntil) {
+		entry = &loginFailures{}
+		entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= max {
+		entry.blockedUntil = now.Add(l.lockoutDuration)
+		locked := !entry.lockedOut
+		entry.lockedOut = true
+		return locked
+	}
+	if entry.failures <= free {
+		return false
+	}
+	delay := l.baseDelay << uint(entry.failures-free-1)
+	if delay > l.maxDelay || delay <= 0 {
+		delay = l.maxDelay
+	}
+	entry.blockedUntil = now.Add(delay)
+	return false
+}
+
+// prune removes the entries whose failures are forgotten
+func (l *loginLockout) prune() {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	for _, entries := range []map[string]*loginFailures{l.users, l.sourceIPs} {
+		for key, entry := range entries {
+			if now.Sub(entry.lastFailure) > l.failureWindow && now.After(entry.blockedUntil) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+func (f *loginFailures) status(lockout types.LoginLockout) types.LoginLockout {
+	lockout.Failures = f.failures
+	lockout.LastFailure = f.lastFailure
+	lockout.BlockedUntil = f.blockedUntil
+	lockout.LockedOut = f.lockedOut && time.Now().Before(f.blockedUntil)
+	return lockout
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/config"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+const refreshTokenBytes = 32
+
+// ErrInvalidRefreshToken is returned for unknown, expired, used or revoked refresh tokens
+var ErrInvalidRefreshToken = errors.New("Invalid refresh token")
+
+// RefreshGrant is what the login asked for, the tokens issued on refresh keep it
+type RefreshGrant struct {
+	// UserName is the name the user logged in with, used to look the user up again
+	UserName string
+	// Groups are the group patterns of the login, see FilterGroupsOnClaims
+	Groups string
+	// ExpiresIn is the lifetime the login asked for(expires_in), 0 for the lifetime allowed for the user
+	ExpiresIn time.Duration
+}
+
+// refreshToken is a issued refresh token. Tokens are kept by the hash of their value so that
+// a dump of the memory does not reveal usable tokens.
+type refreshToken struct {
+	RefreshGrant
+	// family is shared by the tokens rotated from the same login
+	family string
+	expiry time.Time
+	used   bool
+}
+
+// refreshTokenStore holds the refresh tokens in memory, they do not survive a restart
+type refreshTokenStore struct {
+	sync.Mutex
+	tokens map[string]*refreshToken
+}
+
+var refreshTokens = &refreshTokenStore{tokens: map[string]*refreshToken{}}
+
+// RefreshTokensEnabled reports whether login issues refresh tokens(authConfig.refreshTokenTTL is set)
+func RefreshTokensEnabled() bool {
+	return config.AppConfig.AuthConfig.RefreshTokenTTL != ""
+}
+
+// IssueRefreshToken starts a new refresh token family for the grant of a login
+func IssueRefreshToken(grant RefreshGrant) (types.Token, error) {
+	family, err := randomString()
+	if err != nil {
+		return types.Token{}, err
+	}
+	return refreshTokens.issue(family, grant)
+}
+
+// CheckRefreshToken returns the grant a refresh token was issued for without redeeming it, so that
+// the user can be looked up before the token is used up
+func CheckRefreshToken(value string) (RefreshGrant, error) {
+	refreshTokens.Lock()
+	defer refreshTokens.Unlock()
+	token, err := refreshTokens.redeemable(value)
+	if err != nil {
+		return RefreshGrant{}, err
+	}
+	return token.RefreshGrant, nil
+}
+
+// RotateRefreshToken redeems a refresh token and returns its replacement. Every refresh token can be redeemed
+// once. Redeeming a token again means that it leaked, so the whole family, including the replacement issued
+// before, is revoked.
+func RotateRefreshToken(value string) (types.Token, error) {
+	refreshTokens.Lock()
+	token, err := refreshTokens.redeemable(value)
+	if err != nil {
+		refreshTokens.Unlock()
+		return types.Token{}, err
+	}
+	token.used = true
+	refreshTokens.Unlock()
+
+	return refreshTokens.issue(token.family, token.RefreshGrant)
+}
+
+// RevokeRefreshToken revokes the family of the refresh token, e.g. when its user no longer exists
+func RevokeRefreshToken(value string) {
+	refreshTokens.Lock()
+	defer refreshTokens.Unlock()
+	if token, ok := refreshTokens.tokens[hashRefreshToken(value)]; ok {
+		refreshTokens.revokeFamily(token.family)
+	}
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of the user
+func RevokeUserRefreshTokens(userName string) {
+	refreshTokens.Lock()
+	defer refreshTokens.Unlock()
+	for hash, token := range refreshTokens.tokens {
+		if token.UserName == userName {
+			delete(refreshTokens.tokens, hash)
+		}
+	}
+}
+
+func (rs *refreshTokenStore) issue(family string, grant RefreshGrant) (types.Token, error) {
+	value, err := randomString()
+	if err != nil {
+		return types.Token{}, err
+	}
+	ttl := parseDuration(config.AppConfig.AuthConfig.RefreshTokenTTL, 0)
+	if ttl <= 0 {
+		return types.Token{}, errors.New("Refresh tokens are disabled, authConfig.refreshTokenTTL is not set")
+	}
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	rs.Lock()
+	defer rs.Unlock()
+	rs.prune(now)
+	rs.tokens[hashRefreshToken(value)] = &refreshToken{
+		RefreshGrant: grant,
+		family:       family,
+		expiry:       expiry,
+	}
+	return types.Token{JWT: value, Expiry: expiry.Unix()}, nil
+}
+
+// redeemable returns the token of the value unless it is unknown, expired or used. A used token revokes
+// its family. Must be called with the lock held
+func (rs *refreshTokenStore) redeemable(value string) (*refreshToken, error) {
+	token, ok := rs.tokens[hashRefreshToken(value)]
+	if !ok || time.Now().After(token.expiry) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if token.used {
+		rs.revokeFamily(token.family)
+		log.Errorf("Refresh token of %s used twice, revoked all refresh tokens of the login", token.UserName)
+		return nil, ErrInvalidRefreshToken
+	}
+	return token, nil
+}
+
+// revokeFamily removes all tokens of the family. Must be called with the lock held
+func (rs *refreshTokenStore) revokeFamily(family string) {
+	for hash, token := range rs.tokens {
+		if token.family == family {
+			delete(rs.tokens, hash)
+		}
+	}
+}
+
+// prune removes expired tokens. Used tokens are kept until they expire to detect their reuse.
+// Must be called with the lock held
+func (rs *refreshTokenStore) prune(now time.Time) {
+	for hash, token := range rs.tokens {
+		if now.After(token.expiry) {
+			delete(rs.tokens, hash)
+		}
+	}
+}
+
+func hashRefreshToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return string(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+const revocationPruneInterval = 10 * time.Minute
+
+// ErrTokenRevoked is returned by validate for revoked tokens
+var ErrTokenRevoked = errors.New("Token has been revoked")
+
+// revocationList holds the revoked tokens. It is saved to filePath on every change, entries are
+// removed once the tokens they revoke have expired.
+type revocationList struct {
+	sync.RWMutex
+	filePath string
+	tokens   map[string]types.RevokedToken
+	users    map[string]types.RevokedUser
+}
+
+var revocations = &revocationList{
+	tokens: map[string]types.RevokedToken{},
+	users:  map[string]types.RevokedUser{},
+}
+
+// LoadRevocations reads the revocation list from authConfig.revocationFilePath and prunes it periodically.
+// A missing file is an empty list.
+func LoadRevocations(authConfig types.AuthConfig) error {
+	revocations.Lock()
+	defer revocations.Unlock()
+	revocations.filePath = authConfig.RevocationFilePath
+	if revocations.filePath == "" {
+		log.Infof("authConfig.revocationFilePath not set, revoked tokens are kept in memory only")
+	} else {
+		data, err := ioutil.ReadFile(revocations.filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(data) != 0 {
+			var list types.RevocationList
+			if err := json.Unmarshal(data, &list); err != nil {
+				return fmt.Errorf("Invalid revocation file %s : %v", revocations.filePath, err)
+			}
+			for _, token := range list.Tokens {
+				revocations.tokens[token.ID] = token
+			}
+			for _, user := range list.Users {
+				revocations.users[user.Username] = user
+			}
+		}
+		log.Infof("Loaded %d revoked tokens and %d revoked users from %s", len(revocations.tokens), len(revocations.users), revocations.filePath)
+	}
+	go func() {
+		for range time.Tick(revocationPruneInterval) {
+			revocations.prune()
+		}
+	}()
+	return nil
+}
+
+// RevokeToken revokes the token with the jti until its expiry. A zero expiry means the longest lifetime of a token
+func RevokeToken(id string, userName string, expiry time.Time) error {
+	now := time.Now()
+	if expiry.IsZero() {
+		expiry = now.Add(maxTokenLifetime())
+	}
+	revocations.Lock()
+	defer revocations.Unlock()
+	revocations.tokens[id] = types.RevokedToken{ID: id, Username: userName, RevokedAt: now, Expiry: expiry}
+	log.Infof("Revoked token %s of %q", id, userName)
+	return revocations.save()
+}
+
+// RevokeUser revokes all tokens and refresh tokens issued to the user so far
+func RevokeUser(userName string) error {
+	now := time.Now()
+	RevokeUserRefreshTokens(userName)
+	revocations.Lock()
+	defer revocations.Unlock()
+	revocations.users[userName] = types.RevokedUser{Username: userName, RevokedAt: now, Expiry: now.Add(maxTokenLifetime())}
+	log.Infof("Revoked all tokens of %q", userName)
+	return revocations.save()
+}
+
+// Revocations returns the current revocation list
+func Revocations() types.RevocationList {
+	revocations.RLock()
+	defer revocations.RUnlock()
+	return revocations.list()
+}
+
+// Logout revokes the bearer token in the Authorization header
+func Logout(authHeader string) (*types.User, error) {
+	token, err := checkAuthScheme(authHeader)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, errors.New("Token has no jti and can not be revoked, ask an admin to revoke all tokens of the user")
+	}
+	if err := RevokeToken(claims.ID, claims.Username, time.Unix(claims.Expiry, 0)); err != nil {
+		return nil, err
+	}
+	return &types.User{Username: claims.Username, UID: claims.UID, Groups: claims.Groups}, nil
+}
+
+// revoked reports whether the token was revoked by its jti or by its user
+func (rl *revocationList) revoked(claims types.JWTClaimsJSON) bool {
+	rl.RLock()
+	defer rl.RUnlock()
+	if _, ok := rl.tokens[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+	if user, ok := rl.users[claims.Username]; ok && claims.Iat <= user.RevokedAt.Unix() {
+		return true
+	}
+	return false
+}
+
+func (rl *revocationList) prune() {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Now()
+	pruned := 0
+	for id, token := range rl.tokens {
+		if now.After(token.Expiry) {
+			delete(rl.tokens, id)
+			pruned++
+		}
+	}
+	for name, user := range rl.users {
+		if now.After(user.Expiry) {
+			delete(rl.users, name)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return
+	}
+	log.Debugf("Pruned %d expired revocations", pruned)
+	if err := rl.save(); err != nil {
+		log.Errorf("Saving revocation list failed : %v", err)
+	}
+}
+
+// list returns the entries sorted by revocation time. Must be called with the lock held
+func (rl *revocationList) list() types.RevocationList {
+	list := types.RevocationList{Tokens: []types.RevokedToken{}, Users: []types.RevokedUser{}}
+	for _, token := range rl.tokens {
+		list.Tokens = append(list.Tokens, token)
+	}
+	for _, user := range rl.users {
+		list.Users = append(list.Users, user)
+	}
+	sort.Slice(list.Tokens, func(i, j int) bool { return list.Tokens[i].RevokedAt.Before(list.Tokens[j].RevokedAt) })
+	sort.Slice(list.Users, func(i, j int) bool { return list.Users[i].RevokedAt.Before(list.Users[j].RevokedAt) })
+	return list
+}
+
+// save writes the list to a temporary file and renames it, so that a crash never leaves a partial file.
+// Must be called with the lock held
+func (rl *revocationList) save() error {
+	if rl.filePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(rl.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(rl.filePath), filepath.Base(rl.filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), rl.filePath)
+}
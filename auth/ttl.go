@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/config"
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+const defaultTokenTTL = 24 * time.Hour
+
+// TokenTTL returns the lifetime of a token of the user. The shortest of the tokenTTL of the user and the
+// groupTokenTTL of the user's groups is used, authConfig.tokenTTL if none of them is set. A requested lifetime,
+// e.g. expires_in of the login, can only make it shorter. No token lives longer than authConfig.maxTokenTTL.
+func TokenTTL(userDetail types.UserDetails, requested time.Duration) time.Duration {
+	authConfig := config.AppConfig.AuthConfig
+	var ttl time.Duration
+	shorten := func(candidate time.Duration) {
+		if candidate > 0 && (ttl == 0 || candidate < ttl) {
+			ttl = candidate
+		}
+	}
+	shorten(parseDuration(userDetail.TokenTTL, 0))
+	for _, group := range userDetail.Groups {
+		if groupTTL, ok := authConfig.GroupTokenTTL[group]; ok {
+			shorten(parseDuration(groupTTL, 0))
+		}
+	}
+	if ttl == 0 {
+		ttl = parseDuration(authConfig.TokenTTL, defaultTokenTTL)
+	}
+	if max := maxTokenLifetime(); ttl > max {
+		log.Infof("Token lifetime %s of %s is longer than authConfig.maxTokenTTL, using %s", ttl, userDetail.UserName, max)
+		ttl = max
+	}
+	shorten(requested)
+	return ttl
+}
+
+// maxTokenLifetime is the longest time a token can be valid, revocations are kept that long
+func maxTokenLifetime() time.Duration {
+	authConfig := config.AppConfig.AuthConfig
+	if max := parseDuration(authConfig.MaxTokenTTL, 0); max > 0 {
+		return max
+	}
+	max := parseDuration(authConfig.TokenTTL, defaultTokenTTL)
+	for _, groupTTL := range authConfig.GroupTokenTTL {
+		if ttl := parseDuration(groupTTL, 0); ttl > max {
+			max = ttl
+		}
+	}
+	return max
+}
@@ -0,0 +1,157 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"gopkg.in/yaml.v2"
+)
+
+// Policy evaluates SubjectAccessReview requests against the rules of a policy file
+type Policy struct {
+	defaultDecision string
+	rules           []types.PolicyRule
+}
+
+// Decision is the outcome of evaluating a request against the policy
+type Decision struct {
+	Effect string
+	Reason string
+}
+
+// LoadPolicy reads and validates the policy file at the given path
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return nil, errors.New("No authorization policy file configured")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("Error reading authorization policy from %s: %s", path, err)
+		return nil, err
+	}
+	var policyConf types.AuthorizationPolicy
+	if yamlErr := yaml.Unmarshal(data, &policyConf); yamlErr != nil {
+		log.Errorf("Error deserializing yaml %v", yamlErr)
+		return nil, yamlErr
+	}
+	return NewPolicy(policyConf)
+}
+
+// NewPolicy validates the given policy and returns its evaluator
+func NewPolicy(policyConf types.AuthorizationPolicy) (*Policy, error) {
+	policy := &Policy{
+		defaultDecision: policyConf.DefaultDecision,
+		rules:           policyConf.Rules,
+	}
+	switch policy.defaultDecision {
+	case "":
+		policy.defaultDecision = types.PolicyNoOpinion
+	case types.PolicyAllow, types.PolicyDeny, types.PolicyNoOpinion:
+	default:
+		return nil, fmt.Errorf("Invalid defaultDecision %q", policy.defaultDecision)
+	}
+	for i, rule := range policy.rules {
+		if rule.Name == "" {
+			policy.rules[i].Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Effect != types.PolicyAllow && rule.Effect != types.PolicyDeny {
+			return nil, fmt.Errorf("Invalid effect %q in rule %s", rule.Effect, policy.rules[i].Name)
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate returns the decision for the request. A matching deny rule always wins over
+// a matching allow rule, and the default decision is used when no rule matches.
+func (p *Policy) Evaluate(spec types.AuthorizationSpec) Decision {
+	var allowedBy string
+	for _, rule := range p.rules {
+		if !matchSubject(rule, spec) || !matchRequest(rule, spec) {
+			continue
+		}
+		if rule.Effect == types.PolicyDeny {
+			return Decision{Effect: types.PolicyDeny, Reason: fmt.Sprintf("Denied by policy rule %s", rule.Name)}
+		}
+		if allowedBy == "" {
+			allowedBy = rule.Name
+		}
+	}
+	if allowedBy != "" {
+		return Decision{Effect: types.PolicyAllow, Reason: fmt.Sprintf("Allowed by policy rule %s", allowedBy)}
+	}
+	return Decision{Effect: p.defaultDecision, Reason: "No policy rule matched"}
+}
+
+func matchSubject(rule types.PolicyRule, spec types.AuthorizationSpec) bool {
+	if spec.User != "" && contains(rule.Users, spec.User) {
+		return true
+	}
+	for _, group := range spec.UserGroups() {
+		if contains(rule.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRequest(rule types.PolicyRule, spec types.AuthorizationSpec) bool {
+	if attr := spec.ResourceAttributes; attr != nil {
+		for _, rr := range rule.ResourceRules {
+			if matchResource(rr, *attr) {
+				return true
+			}
+		}
+		return false
+	}
+	if attr := spec.NonResourceAttributes; attr != nil {
+		for _, nr := range rule.NonResourceRules {
+			if contains(nr.Verbs, attr.Verb) && matchPath(nr.Paths, attr.Path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchResource(rule types.ResourceRule, attr types.ResourceAttributes) bool {
+	resource := attr.Resource
+	if attr.Subresource != "" {
+		resource += "/" + attr.Subresource
+	}
+	if !contains(rule.Verbs, attr.Verb) || !contains(rule.APIGroups, attr.Group) || !contains(rule.Resources, resource) {
+		return false
+	}
+	// Empty resourceNames and namespaces mean the rule is not restricted on them
+	if len(rule.ResourceNames) != 0 && !contains(rule.ResourceNames, attr.Name) {
+		return false
+	}
+	if len(rule.Namespaces) != 0 && !contains(rule.Namespaces, attr.Namespace) {
+		return false
+	}
+	return true
+}
+
+func matchPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == path {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/types"
@@ -11,6 +13,11 @@ import (
 	"github.com/ghodss/yaml"
 )
 
+const (
+	configFileEnvVar   = "CONFIG_FILE"
+	configFileFallback = "auth_config.yaml"
+)
+
 var AppConfig = types.ConfigMap{}
 
 // Load will load configuration from k8s config map.
@@ -18,24 +25,61 @@ var AppConfig = types.ConfigMap{}
 func Load() (*types.ConfigMap, error) {
 	var config types.ConfigMap
 
-	data, err := ReadConfigData("Main auth data", "CONFIG_FILE", "auth_config.yaml")
+	data, err := ReadConfigData("Main auth data", configFileEnvVar, configFileFallback)
 	if err != nil {
 		return &config, err
 	}
-	if yamlErr := yaml.Unmarshal(data, &config); yamlErr != nil {
+	if yamlErr := parse(data, &config); yamlErr != nil {
 		log.Fatalf("Error deserializing yaml data starting %s: %s", string(data[0:20]), yamlErr.Error())
 		return nil, yamlErr
 	}
+	if len(config.AuthConfig.AuthSigningKey) == 0 && len(config.AuthConfig.ActiveKeyID) == 0 {
+		log.Fatal("Unable to get SigningKey from environment variable(AUTH_SIGING_KEY) or application configuration(authSigningKey or activeKeyID)")
+	}
+	AppConfig = config
+	return &config, nil
+}
+
+// Reload reads the main configuration file again. Unlike Load it never exits the process,
+// so that a broken edit of a mounted config map does not take the service down.
+func Reload() (*types.ConfigMap, error) {
+	var config types.ConfigMap
+	data, err := ioutil.ReadFile(FilePath())
+	if err != nil {
+		return nil, err
+	}
+	if err := parse(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// FilePath returns the path of the main configuration file
+func FilePath() string {
+	fileP, _ := configFilePath(configFileEnvVar, configFileFallback)
+	return fileP
+}
 
+// ModTime returns the modification time of the file, zero time if the file can not be read
+func ModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func parse(data []byte, config *types.ConfigMap) error {
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return err
+	}
 	signingKey := os.Getenv("AUTH_SIGING_KEY")
 	if len(signingKey) != 0 {
 		config.AuthConfig.AuthSigningKey = signingKey
 	}
-	if len(config.AuthConfig.AuthSigningKey) == 0 {
-		log.Fatal("Unable to get SigningKey from environment variable(AUTH_SIGING_KEY) or application configuration")
-	}
-	AppConfig = config
-	return &config, nil
+	// The issuer is compared as a string, the iss claim and the discovery document must have the same value
+	config.AuthConfig.Issuer = strings.TrimSuffix(config.AuthConfig.Issuer, "/")
+	return nil
 }
 
 // ReadConfigData will read the data from a k8s config map.
@@ -46,14 +90,10 @@ func ReadConfigData(name string, envvar string, fallback string) ([]byte, error)
 
 	var data []byte
 
-	fileP := os.Getenv(envvar) //Get File path from Env Var
-
-	if fileP == "" { // None derived from env var & mapped k8s config-map so fall back
-		wd, _ := os.Getwd()
-		fileP = filepath.Join(wd, "config", fallback)
+	fileP, fromEnv := configFilePath(envvar, fallback)
+	if !fromEnv { // None derived from env var & mapped k8s config-map so fall back
 		log.Infof("No k8s %s given in %s, falling back to: %s", name, envvar, fileP)
 	} else {
-		fileP = filepath.FromSlash(fileP)
 		log.Infof("Loading %s from k8s config Map: %s", name, fileP)
 		name += " via k8s"
 	}
@@ -67,3 +107,12 @@ func ReadConfigData(name string, envvar string, fallback string) ([]byte, error)
 	return data, nil
 
 }
+
+func configFilePath(envvar string, fallback string) (string, bool) {
+	fileP := os.Getenv(envvar) //Get File path from Env Var
+	if fileP == "" {
+		wd, _ := os.Getwd()
+		return filepath.Join(wd, "config", fallback), false
+	}
+	return filepath.FromSlash(fileP), true
+}
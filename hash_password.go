@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dinumathai/auth-webhook-sample/util/password"
+)
+
+// hashPassword implements the hash-password command. It prints the hash of the password given as
+// argument, or read from stdin, to be used in the password field of the user details file.
+func hashPassword(args []string) int {
+	flags := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	algorithm := flags.String("algorithm", password.Bcrypt, "Hash algorithm, one of "+strings.Join(password.Algorithms, ", "))
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s hash-password [-algorithm name] [password]\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "The password is read from stdin if not given.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var plain string
+	switch flags.NArg() {
+	case 0:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "Unable to read password from stdin : %v\n", err)
+			return 1
+		}
+		plain = strings.TrimRight(line, "\r\n")
+	case 1:
+		plain = flags.Arg(0)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if plain == "" {
+		fmt.Fprintln(os.Stderr, "Password must not be empty")
+		return 1
+	}
+
+	hashed, err := password.Hash(*algorithm, plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(hashed)
+	return 0
+}
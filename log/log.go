@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -23,43 +24,69 @@ func init() {
 	}
 
 	logrus.SetOutput(loggerOut)
-	logrus.SetLevel(getLogLevel())
-	logrus.Infof("Instantiated _logger. Log level set to %s", getLogLevel())
+	logrus.SetFormatter(getFormatter())
+	level, known := getLogLevel()
+	logrus.SetLevel(level)
+	if !known {
+		logrus.Warnf("Unknown LOG_LEVEL %q, expected DEBUG, INFO, WARN or ERROR. Log level set to %s", os.Getenv("LOG_LEVEL"), level)
+	}
+	logrus.Debugf("Instantiated _logger. Log level set to %s", level)
 }
 
-func getLogLevel() logrus.Level {
+// getLogLevel returns the level set in LOG_LEVEL, INFO if not set. known is false for an unknown level
+func getLogLevel() (level logrus.Level, known bool) {
 	logLevel := os.Getenv("LOG_LEVEL")
 
-	switch logLevel {
+	switch strings.ToUpper(logLevel) {
 	case "DEBUG":
-		return logrus.DebugLevel
-	case "INFO":
-		return logrus.InfoLevel
+		return logrus.DebugLevel, true
+	case "", "INFO":
+		return logrus.InfoLevel, true
+	case "WARN", "WARNING":
+		return logrus.WarnLevel, true
 	case "ERROR":
-		return logrus.InfoLevel
+		return logrus.ErrorLevel, true
 	default:
-		return logrus.DebugLevel
+		return logrus.InfoLevel, false
+	}
+}
+
+// getFormatter returns the formatter set in LOG_FORMAT, text or json. Tokens and passwords are redacted by both
+func getFormatter() logrus.Formatter {
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		return &redactingFormatter{&logrus.JSONFormatter{}}
 	}
+	return &redactingFormatter{&logrus.TextFormatter{}}
 }
 
 // Debug - Loging
 func Debug(args ...interface{}) {
-	logrus.Info(args...)
+	logrus.Debug(args...)
 }
 
 // Debugf - Loging
 func Debugf(format string, args ...interface{}) {
-	logrus.Infof(format, args...)
+	logrus.Debugf(format, args...)
 }
 
 // Info - Loging
 func Info(args ...interface{}) {
-	logrus.Warning(args...)
+	logrus.Info(args...)
 }
 
 // Infof - Loging
 func Infof(format string, args ...interface{}) {
-	logrus.Warningf(format, args...)
+	logrus.Infof(format, args...)
+}
+
+// Warn - Loging
+func Warn(args ...interface{}) {
+	logrus.Warn(args...)
+}
+
+// Warnf - Loging
+func Warnf(format string, args ...interface{}) {
+	logrus.Warnf(format, args...)
 }
 
 // Error - Loging
@@ -0,0 +1,61 @@
+package log
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// Headers whose values are never logged
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	// Credentials of an Authorization header, e.g. in a dump of the request headers
+	authSchemePattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[^\s\]"',]+`)
+	// A JWT anywhere in the message
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+	// JSON fields like "token", "refreshToken" or "password", e.g. in a dump of the request body
+	jsonSecretPattern = regexp.MustCompile(`(?i)("[a-z_]*(token|password|secret)"\s*:\s*)"(\\.|[^"\\])*"`)
+)
+
+// RedactHeaders returns a copy of the headers without the credentials, for logging
+func RedactHeaders(header http.Header) http.Header {
+	clone := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := clone[name]; ok {
+			clone[name] = []string{redacted}
+		}
+	}
+	return clone
+}
+
+// Redact removes bearer and basic credentials, JWTs and token or password fields of JSON from the text
+func Redact(text string) string {
+	text = authSchemePattern.ReplaceAllString(text, "$1 "+redacted)
+	text = jwtPattern.ReplaceAllString(text, redacted)
+	return jsonSecretPattern.ReplaceAllString(text, `$1"`+redacted+`"`)
+}
+
+// redactingFormatter redacts the message and the string fields of every entry, so that a token
+// reaching a log line by mistake is not written out
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redactedEntry := *entry
+	redactedEntry.Message = Redact(entry.Message)
+	if len(entry.Data) != 0 {
+		redactedEntry.Data = make(logrus.Fields, len(entry.Data))
+		for key, value := range entry.Data {
+			if text, ok := value.(string); ok {
+				value = Redact(text)
+			}
+			redactedEntry.Data[key] = value
+		}
+	}
+	return f.Formatter.Format(&redactedEntry)
+}
@@ -0,0 +1,106 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is read from the request, if valid, and set on the response
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestFieldsKey struct{}
+
+// requestFields are added to the log lines of a request. The username is filled in by the handler
+// once it is known, the request is handled in one goroutine so it is not locked
+type requestFields struct {
+	requestID  string
+	route      string
+	remoteAddr string
+	username   string
+}
+
+// Entry logs with the fields of a request
+type Entry struct {
+	entry *logrus.Entry
+}
+
+// WithRequestFields returns the request with a request ID and the route in its context. The request ID
+// is taken from the X-Request-ID header if the caller sent one
+func WithRequestFields(r *http.Request, route string) *http.Request {
+	requestID := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	fields := &requestFields{requestID: requestID, route: route, remoteAddr: r.RemoteAddr}
+	return r.WithContext(context.WithValue(r.Context(), requestFieldsKey{}, fields))
+}
+
+// RequestID returns the ID of the request, empty if it has none
+func RequestID(r *http.Request) string {
+	if fields, ok := r.Context().Value(requestFieldsKey{}).(*requestFields); ok {
+		return fields.requestID
+	}
+	return ""
+}
+
+// SetUsername adds the user the request is made by or for to the log lines of the request
+func SetUsername(r *http.Request, username string) {
+	if fields, ok := r.Context().Value(requestFieldsKey{}).(*requestFields); ok {
+		fields.username = username
+	}
+}
+
+// WithRequest returns a logger adding the request ID, route, remote address and username of the request
+func WithRequest(r *http.Request) Entry {
+	fields, ok := r.Context().Value(requestFieldsKey{}).(*requestFields)
+	if !ok {
+		return Entry{logrus.NewEntry(logrus.StandardLogger())}
+	}
+	logFields := logrus.Fields{
+		"requestID":  fields.requestID,
+		"route":      fields.route,
+		"remoteAddr": fields.remoteAddr,
+	}
+	if fields.username != "" {
+		logFields["username"] = fields.username
+	}
+	return Entry{logrus.WithFields(logFields)}
+}
+
+// WithField returns a logger adding the field as well
+func (e Entry) WithField(key string, value interface{}) Entry {
+	return Entry{e.entry.WithField(key, value)}
+}
+
+// Debugf - Loging
+func (e Entry) Debugf(format string, args ...interface{}) {
+	e.entry.Debugf(format, args...)
+}
+
+// Infof - Loging
+func (e Entry) Infof(format string, args ...interface{}) {
+	e.entry.Infof(format, args...)
+}
+
+// Warnf - Loging
+func (e Entry) Warnf(format string, args ...interface{}) {
+	e.entry.Warnf(format, args...)
+}
+
+// Errorf - Loging
+func (e Entry) Errorf(format string, args ...interface{}) {
+	e.entry.Errorf(format, args...)
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -3,13 +3,24 @@ package server
 import (
 	"github.com/dinumathai/auth-webhook-sample/api"
 	"github.com/dinumathai/auth-webhook-sample/auth"
+	"github.com/dinumathai/auth-webhook-sample/log"
 	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/userstore"
 	"github.com/dinumathai/auth-webhook-sample/util/health"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 	"github.com/dinumathai/auth-webhook-sample/util/routing"
 )
 
+// webhookRoutes are the routes called by the kube-apiservers, refer api.RequireWebhookCaller
+var webhookRoutes = []string{"V0-Validate", "V0-Authorize"}
+
 //BuildRoutes builds routes for this service
-func BuildRoutes(config *types.ConfigMap) []routing.Route {
+func BuildRoutes(config *types.ConfigMap, callers *api.WebhookCallers) []routing.Route {
+	store, err := userstore.New(config)
+	if err != nil {
+		log.Fatalf("User store not configured correctly - %v", err)
+	}
+
 	var routes = routing.Routes{
 		routing.Route{
 			Name:        "HealthCheck",
@@ -17,24 +28,101 @@ func BuildRoutes(config *types.ConfigMap) []routing.Route {
 			Pattern:     "/health",
 			HandlerFunc: health.PongHandler,
 		},
+		routing.Route{
+			Name:        "Metrics",
+			Method:      "GET",
+			Pattern:     "/metrics",
+			HandlerFunc: metrics.Handler,
+		},
 		routing.Route{
 			Name:        "V0-Login",
 			Method:      "POST",
 			Pattern:     "/v0/login",
-			HandlerFunc: api.LoginV0Handler(config),
+			HandlerFunc: api.LoginV0Handler(store),
+		},
+		routing.Route{
+			Name:        "V0-Refresh",
+			Method:      "POST",
+			Pattern:     "/v0/token/refresh",
+			HandlerFunc: api.RefreshV0Handler(store),
+		},
+		routing.Route{
+			Name:        "V0-Logout",
+			Method:      "POST",
+			Pattern:     "/v0/logout",
+			HandlerFunc: api.LogoutV0Handler,
 		},
 		routing.Route{
 			Name:        "V0-Validate",
 			Method:      "POST",
 			Pattern:     "/v0/authenticate",
-			HandlerFunc: api.ValidationHandler(auth.V0),
+			HandlerFunc: api.RequireWebhookCaller(callers, api.ValidationHandler(config, auth.V0)),
 		},
 		routing.Route{
 			Name:        "V0-Authorize",
 			Method:      "POST",
 			Pattern:     "/v0/authorize",
-			HandlerFunc: api.AuthorizeV0Handler(auth.V0),
+			HandlerFunc: api.RequireWebhookCaller(callers, api.AuthorizeV0Handler(config)),
+		},
+		routing.Route{
+			Name:        "JWKS",
+			Method:      "GET",
+			Pattern:     api.JWKSPath,
+			HandlerFunc: api.JWKSHandler,
+		},
+		routing.Route{
+			Name:        "Admin-Keys",
+			Method:      "GET",
+			Pattern:     "/v0/admin/keys",
+			HandlerFunc: api.RequireAdmin(config, api.KeyStatusHandler),
+		},
+		routing.Route{
+			Name:        "Admin-Keys-Rotate",
+			Method:      "POST",
+			Pattern:     "/v0/admin/keys/rotate",
+			HandlerFunc: api.RequireAdmin(config, api.RotateKeysHandler),
+		},
+		routing.Route{
+			Name:        "Admin-Revocations",
+			Method:      "GET",
+			Pattern:     "/v0/admin/revocations",
+			HandlerFunc: api.RequireAdmin(config, api.RevocationsHandler),
 		},
+		routing.Route{
+			Name:        "Admin-Revoke-User",
+			Method:      "POST",
+			Pattern:     "/v0/admin/revocations/user",
+			HandlerFunc: api.RequireAdmin(config, api.RevokeUserHandler),
+		},
+		routing.Route{
+			Name:        "Admin-Revoke-Token",
+			Method:      "POST",
+			Pattern:     "/v0/admin/revocations/token",
+			HandlerFunc: api.RequireAdmin(config, api.RevokeTokenHandler),
+		},
+		routing.Route{
+			Name:        "Admin-Lockouts",
+			Method:      "GET",
+			Pattern:     "/v0/admin/lockouts",
+			HandlerFunc: api.RequireAdmin(config, api.LockoutsHandler),
+		},
+		routing.Route{
+			Name:        "Admin-Lockouts-Clear",
+			Method:      "POST",
+			Pattern:     "/v0/admin/lockouts/clear",
+			HandlerFunc: api.RequireAdmin(config, api.ClearLockoutHandler),
+		},
+	}
+	// The discovery issuer must match the iss claim of the tokens, it is not taken from the request
+	if config.AuthConfig.Issuer != "" {
+		routes = append(routes, routing.Route{
+			Name:        "OpenID-Configuration",
+			Method:      "GET",
+			Pattern:     "/.well-known/openid-configuration",
+			HandlerFunc: api.OpenIDConfigurationHandler(config),
+		})
+	} else {
+		log.Infof("authConfig.issuer not set, /.well-known/openid-configuration is not served")
 	}
 	return routes
 }
@@ -1,11 +1,23 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dinumathai/auth-webhook-sample/api"
 	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/health"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 	"github.com/dinumathai/auth-webhook-sample/util/routing"
 	"github.com/dinumathai/auth-webhook-sample/util/security"
 
@@ -17,32 +29,187 @@ const (
 	authSSLKeyEnvVar = "AUTH_CERT_TLS_KEY"
 )
 
-//Start starts the server
-func Start(config *types.ConfigMap) {
-	if (os.Getenv(authSSLCrtEnvVar) != "") && (os.Getenv(authSSLKeyEnvVar) != "") {
-		log.Infof("Loading HTTPS certificates....")
-		err := security.LoadCertsFromEnvVariable(authSSLCrtEnvVar, authSSLKeyEnvVar)
-		if err != nil {
-			log.Infof("SSL Certs Not available...")
-		} else {
-			log.Infof("SSL Certs loaded successfully...")
+const (
+	defaultCertReloadInterval = time.Minute
+
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// Start starts the server and blocks until it is stopped by SIGTERM/SIGINT. In-flight requests are
+// drained for up to httpServer.shutdownTimeout. Returns an error if the listener fails or the drain times out.
+func Start(config *types.ConfigMap) error {
+	certificate, err := loadCertificate(config.AuthConfig)
+	if err != nil {
+		return fmt.Errorf("TLS certificate not loaded correctly - %v", err)
+	}
+	serverConf := config.AuthConfig.HTTPServer
+	server := &http.Server{
+		Addr:           ":" + strconv.Itoa(config.AuthConfig.ServerAddress),
+		MaxHeaderBytes: serverConf.MaxHeaderBytes,
+	}
+	durations := []struct {
+		name     string
+		value    string
+		fallback time.Duration
+		target   *time.Duration
+	}{
+		{"readTimeout", serverConf.ReadTimeout, defaultReadTimeout, &server.ReadTimeout},
+		{"readHeaderTimeout", serverConf.ReadHeaderTimeout, defaultReadHeaderTimeout, &server.ReadHeaderTimeout},
+		{"writeTimeout", serverConf.WriteTimeout, defaultWriteTimeout, &server.WriteTimeout},
+		{"idleTimeout", serverConf.IdleTimeout, defaultIdleTimeout, &server.IdleTimeout},
+	}
+	for _, duration := range durations {
+		*duration.target = duration.fallback
+		if duration.value != "" {
+			if *duration.target, err = time.ParseDuration(duration.value); err != nil {
+				return fmt.Errorf("Invalid authConfig.httpServer.%s : %v", duration.name, err)
+			}
+		}
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if serverConf.ShutdownTimeout != "" {
+		if shutdownTimeout, err = time.ParseDuration(serverConf.ShutdownTimeout); err != nil {
+			return fmt.Errorf("Invalid authConfig.httpServer.shutdownTimeout : %v", err)
 		}
 	}
 
-	router := routing.BuildRouter(BuildRoutes(config))
+	callers, err := api.NewWebhookCallers(config.AuthConfig.WebhookCallers)
+	if err != nil {
+		return fmt.Errorf("Webhook callers not configured correctly - %v", err)
+	}
+	limiter, err := api.NewRateLimiter(config.AuthConfig.RateLimit, callers, webhookRoutes...)
+	if err != nil {
+		return fmt.Errorf("Rate limits not configured correctly - %v", err)
+	}
+	router := routing.BuildRouter(BuildRoutes(config, callers), limiter.Middleware)
 	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("./swaggerui/"))))
-	http.Handle("/", router)
+	server.Handler = router
+
+	if certificate != nil {
+		tlsConfig, tlsErr := security.ServerTLSConfig(config.AuthConfig.TLS)
+		if tlsErr != nil {
+			return fmt.Errorf("TLS not configured correctly - %v", tlsErr)
+		}
+		if config.AuthConfig.WebhookCallers.ClientCAFile != "" && tlsConfig.ClientAuth == tls.NoClientCert {
+			// The certificate is verified by the webhook endpoints, other endpoints do not need one
+			tlsConfig.ClientAuth = tls.RequestClientCert
+		}
+		tlsConfig.GetCertificate = certificate.GetCertificate
+		server.TLSConfig = tlsConfig
+	} else if config.AuthConfig.WebhookCallers.ClientCAFile != "" || config.AuthConfig.TLS.ClientAuth != "" {
+		log.Errorf("Client certificates can not be verified without HTTPS")
+	}
 
 	log.Infof("Starting Server...")
-	var err error
-	if (os.Getenv(authSSLCrtEnvVar) != "") && (os.Getenv(authSSLKeyEnvVar) != "") {
-		log.Info("Starting server with SSL on port ", config.AuthConfig.ServerAddress)
-		err = http.ListenAndServeTLS(":"+strconv.Itoa(config.AuthConfig.ServerAddress), security.CrtPath, security.KeyPath, nil)
-	} else {
-		log.Info("DEV MODE - Starting HTTP server on port ", config.AuthConfig.ServerAddress)
-		err = http.ListenAndServe(":"+strconv.Itoa(config.AuthConfig.ServerAddress), nil)
+	listenErr := make(chan error, 1)
+	go func() {
+		if certificate != nil {
+			log.Info("Starting server with SSL on port ", config.AuthConfig.ServerAddress)
+			listenErr <- server.ListenAndServeTLS("", "")
+		} else {
+			log.Info("DEV MODE - Starting HTTP server on port ", config.AuthConfig.ServerAddress)
+			listenErr <- server.ListenAndServe()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-listenErr:
+		return fmt.Errorf("Server failed - %v", err)
+	case sig := <-stop:
+		log.Infof("Received %s, shutting down, waiting up to %s for in-flight requests", sig, shutdownTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("Shutdown did not complete - %v", err)
+	}
+	log.Infof("Server stopped")
+	return nil
+}
+
+// loadCertificate loads the serving certificate from authConfig.tls.certFile/keyFile and watches the files,
+// or from the env variables. Returns nil if neither is set.
+func loadCertificate(authConfig types.AuthConfig) (*security.CertificateReloader, error) {
+	tlsConf := authConfig.TLS
+	var certificate *security.CertificateReloader
+	switch {
+	case tlsConf.CertFile != "" || tlsConf.KeyFile != "":
+		log.Infof("Loading HTTPS certificates from %s and %s", tlsConf.CertFile, tlsConf.KeyFile)
+		reloader, err := security.NewCertificateReloader(tlsConf.CertFile, tlsConf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		interval := defaultCertReloadInterval
+		if tlsConf.ReloadInterval != "" {
+			if interval, err = time.ParseDuration(tlsConf.ReloadInterval); err != nil {
+				return nil, fmt.Errorf("Invalid authConfig.tls.reloadInterval : %v", err)
+			}
+		}
+		reloader.Watch(interval)
+		certificate = reloader
+	case os.Getenv(authSSLCrtEnvVar) != "" && os.Getenv(authSSLKeyEnvVar) != "":
+		log.Infof("Loading HTTPS certificates from %s and %s", authSSLCrtEnvVar, authSSLKeyEnvVar)
+		cert, err := security.CertificateFromEnvVariable(authSSLCrtEnvVar, authSSLKeyEnvVar)
+		if err != nil {
+			return nil, err
+		}
+		certificate = security.StaticCertificate(cert)
+	case tlsConf.SelfSigned.Directory != "":
+		reloader, err := bootstrapCertificate(authConfig)
+		if err != nil {
+			return nil, err
+		}
+		certificate = reloader
+	default:
+		return nil, nil
+	}
+	health.RegisterStatus("tlsCertificate", func() interface{} { return certificate.Status() })
+	metrics.RegisterCertificateExpiry(func() time.Time { return certificate.Status().NotAfter })
+	log.Infof("SSL Certs loaded successfully...")
+	return certificate, nil
+}
+
+// bootstrapCertificate generates or reuses the self-signed certificates and writes the kubeconfigs
+// for the kube-apiserver to the same directory
+func bootstrapCertificate(authConfig types.AuthConfig) (*security.CertificateReloader, error) {
+	conf := authConfig.TLS.SelfSigned
+	caPEM, err := security.BootstrapCertificates(conf)
+	if err != nil {
+		return nil, err
 	}
+	reloader, err := security.NewCertificateReloader(filepath.Join(conf.Directory, security.BootstrapCert), filepath.Join(conf.Directory, security.BootstrapKey))
 	if err != nil {
-		log.Info("Starting server - Failed : " + err.Error())
+		return nil, err
+	}
+
+	serverURL := strings.TrimSuffix(conf.ServerURL, "/")
+	if serverURL == "" {
+		serverURL = "https://" + conf.Hosts[0] + ":" + strconv.Itoa(authConfig.ServerAddress)
+	}
+	var token string
+	for _, callerToken := range authConfig.WebhookCallers.Tokens {
+		if callerToken.Token != "" {
+			token = callerToken.Token
+			break
+		}
+	}
+	for _, webhook := range []struct{ name, path, file string }{
+		{"authentication-service", "/v0/authenticate", "auth-webhook-conf.yaml"},
+		{"authorize-service", "/v0/authorize", "authorize-webhook-conf.yaml"},
+	} {
+		kubeconfig := security.WebhookKubeconfig(webhook.name, serverURL+webhook.path, caPEM, token)
+		if err := ioutil.WriteFile(filepath.Join(conf.Directory, webhook.file), []byte(kubeconfig), 0600); err != nil {
+			return nil, err
+		}
 	}
+	fmt.Printf("Self-signed CA certificate(%s):\n%s\n", filepath.Join(conf.Directory, security.BootstrapCACert), caPEM)
+	fmt.Printf("Webhook kubeconfigs for the kube-apiserver(--authentication-token-webhook-config-file, --authorization-webhook-config-file):\n  %s\n  %s\n",
+		filepath.Join(conf.Directory, "auth-webhook-conf.yaml"), filepath.Join(conf.Directory, "authorize-webhook-conf.yaml"))
+	return reloader, nil
 }
@@ -0,0 +1,39 @@
+package types
+
+// Policy decisions and rule effects
+const (
+	PolicyAllow     = "allow"
+	PolicyDeny      = "deny"
+	PolicyNoOpinion = "noOpinion"
+)
+
+// AuthorizationPolicy - Policy evaluated by the authorization webhook
+type AuthorizationPolicy struct {
+	DefaultDecision string       `yaml:"defaultDecision"`
+	Rules           []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule - Grants or denies access to the users and groups it lists
+type PolicyRule struct {
+	Name             string            `yaml:"name"`
+	Effect           string            `yaml:"effect"`
+	Users            []string          `yaml:"users"`
+	Groups           []string          `yaml:"groups"`
+	ResourceRules    []ResourceRule    `yaml:"resourceRules"`
+	NonResourceRules []NonResourceRule `yaml:"nonResourceRules"`
+}
+
+// ResourceRule - Kubernetes resources a rule applies to. "*" matches everything
+type ResourceRule struct {
+	Verbs         []string `yaml:"verbs"`
+	APIGroups     []string `yaml:"apiGroups"`
+	Resources     []string `yaml:"resources"`
+	ResourceNames []string `yaml:"resourceNames"`
+	Namespaces    []string `yaml:"namespaces"`
+}
+
+// NonResourceRule - Non resource paths a rule applies to. A trailing "*" matches any suffix
+type NonResourceRule struct {
+	Verbs []string `yaml:"verbs"`
+	Paths []string `yaml:"paths"`
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -15,12 +16,309 @@ type AuthConfig struct {
 	V0             UserMeta `yaml:"v0"`
 	ServerAddress  int      `yaml:"serverAddress"`
 	AuthSigningKey string   `yaml:"authSigningKey"`
+	// AuthorizationPolicyFilePath - Policy evaluated by the authorization webhook
+	AuthorizationPolicyFilePath string `yaml:"authorizationPolicyFilePath"`
+	// SigningKeys - Asymmetric keys used to sign and verify tokens, selected by the kid header
+	SigningKeys []SigningKey `yaml:"signingKeys"`
+	// ActiveKeyID - Key from SigningKeys used to sign new tokens. AuthSigningKey is used if not set
+	ActiveKeyID string `yaml:"activeKeyID"`
+	// Issuer - Public URL of this service. Set as iss claim and published in the openid configuration
+	Issuer string `yaml:"issuer"`
+	// KeyRetirementGrace - How long a key that is no longer active is accepted for verification. Default 24h
+	KeyRetirementGrace string `yaml:"keyRetirementGrace"`
+	// KeyReloadInterval - How often the config and key files are checked for changes. Default 1m, 0 disables
+	KeyReloadInterval string `yaml:"keyReloadInterval"`
+	// AdminGroups - Users with one of these groups can call the /v0/admin APIs
+	AdminGroups []string `yaml:"adminGroups"`
+	// TokenAudiences - Set as aud claim of issued tokens and checked against spec.audiences of a TokenReview
+	TokenAudiences []string `yaml:"tokenAudiences"`
+	// AllowTokenWithoutAudience - Accept tokens without aud claim for any audiences of a TokenReview
+	AllowTokenWithoutAudience bool `yaml:"allowTokenWithoutAudience"`
+	// LegacyErrorResponses - Answer invalid tokens with HTTP 400 and {"error": ...} instead of an unauthenticated TokenReview
+	LegacyErrorResponses bool `yaml:"legacyErrorResponses"`
+	// RefreshTokenTTL - Lifetime of the refresh tokens returned by login, e.g. 720h. Refresh tokens are disabled if not set
+	RefreshTokenTTL string `yaml:"refreshTokenTTL"`
+	// TokenTTL - Lifetime of issued tokens unless the user or one of the user's groups has one. Default 24h
+	TokenTTL string `yaml:"tokenTTL"`
+	// GroupTokenTTL - Lifetime of the tokens of users in the group. The shortest of the user's lifetimes is used
+	GroupTokenTTL map[string]string `yaml:"groupTokenTTL"`
+	// MaxTokenTTL - No token is valid for longer. Default the longest of tokenTTL and groupTokenTTL
+	MaxTokenTTL string `yaml:"maxTokenTTL"`
+	// WebhookCallers - Credentials the kube-apiserver must present to call the webhook endpoints
+	WebhookCallers WebhookCallerConfig `yaml:"webhookCallers"`
+	// TLS - Client certificate verification and protocol settings of the HTTPS server
+	TLS TLSConfig `yaml:"tls"`
+	// HTTPServer - Timeouts and limits of the HTTP server
+	HTTPServer HTTPServerConfig `yaml:"httpServer"`
+	// RevocationFilePath - File the revoked tokens are saved in. Revocations are lost on restart if not set
+	RevocationFilePath string `yaml:"revocationFilePath"`
+	// Audit - Audit log of logins, TokenReviews and SubjectAccessReviews
+	Audit AuditConfig `yaml:"audit"`
+	// LoginLockout - Backoff and lockout after failed logins
+	LoginLockout LoginLockoutConfig `yaml:"loginLockout"`
+	// RateLimit - Request rate limits per route and client
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+}
+
+// RateLimitConfig - Token bucket limits per route and client. Requests are not limited if there are no rules
+type RateLimitConfig struct {
+	// Rules - The first rule listing the route of a request applies, "*" matches every route
+	Rules []RateLimitRule `yaml:"rules"`
+	// WebhookCallerLimit - Limit of each authenticated kube-apiserver(authConfig.webhookCallers). Exempt if not set
+	WebhookCallerLimit *RateLimitRule `yaml:"webhookCallerLimit"`
+}
+
+// RateLimitRule - A bucket of Burst requests refilled at RequestsPerSecond for every route and client
+type RateLimitRule struct {
+	Routes            []string `yaml:"routes"`
+	RequestsPerSecond float64  `yaml:"requestsPerSecond"`
+	Burst             int      `yaml:"burst"`
+	// Key - What identifies the client, ip(default), certificate(common name of a verified client certificate)
+	// or token(subject of a valid bearer token). Requests without a certificate or token are limited by ip
+	Key string `yaml:"key"`
+}
+
+// LoginLockoutConfig - Failed logins are counted per username and per source IP. After a failure further
+// logins are refused for a delay doubling with every failure, after MaxFailures for LockoutDuration
+type LoginLockoutConfig struct {
+	// Disabled - Turn the protection off
+	Disabled bool `yaml:"disabled"`
+	// MaxFailures - Failures of a username before it is locked out. Default 5
+	MaxFailures int `yaml:"maxFailures"`
+	// MaxSourceIPFailures - Failures from a source IP before it is locked out. Default 20
+	MaxSourceIPFailures int `yaml:"maxSourceIPFailures"`
+	// BaseDelay - Delay after the first failure. Default 1s
+	BaseDelay string `yaml:"baseDelay"`
+	// MaxDelay - Longest delay before the lockout. Default 1m
+	MaxDelay string `yaml:"maxDelay"`
+	// LockoutDuration - How long a locked out username or source IP is refused. Default 15m
+	LockoutDuration string `yaml:"lockoutDuration"`
+	// FailureWindow - Failures are forgotten when there was none for this long. Default 15m
+	FailureWindow string `yaml:"failureWindow"`
+}
+
+// AuditConfig - Audit log written as hash-chained JSON lines, separate from the application log
+type AuditConfig struct {
+	// FilePath - File the audit records are appended to. The audit log is disabled if not set
+	FilePath string `yaml:"filePath"`
+	// MaxSizeMB - The file is rotated when it is bigger. Default 100
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// MaxBackups - Number of rotated files kept, the oldest are deleted. Default 10, -1 keeps all
+	MaxBackups int `yaml:"maxBackups"`
+}
+
+// SigningKey - A HMAC secret or a PEM encoded key used for signing(PrivateKeyFile) or only for verification(PublicKeyFile)
+type SigningKey struct {
+	KeyID          string `yaml:"keyID"`
+	Algorithm      string `yaml:"algorithm"`
+	Secret         string `yaml:"secret"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	PublicKeyFile  string `yaml:"publicKeyFile"`
+	// RetireAt - RFC3339 time after which tokens signed with the key are no longer accepted
+	RetireAt string `yaml:"retireAt"`
+}
+
+// KeyRingStatus - Keys currently used to sign and verify tokens
+type KeyRingStatus struct {
+	ActiveKeyID string      `json:"activeKeyID,omitempty"`
+	Keys        []KeyStatus `json:"keys"`
+	LastReload  time.Time   `json:"lastReload"`
+	LastError   string      `json:"lastError,omitempty"`
+}
+
+// KeyStatus - A key of the key ring
+type KeyStatus struct {
+	KeyID     string     `json:"keyID"`
+	Algorithm string     `json:"algorithm"`
+	CanSign   bool       `json:"canSign"`
+	RetireAt  *time.Time `json:"retireAt,omitempty"`
 }
 
 // UserMeta - User detail for V0 api
 type UserMeta struct {
-	Source             string `yaml:"source"`
-	UserDetailFilePath string `yaml:"userDetailFilePath"`
+	Source             string     `yaml:"source"`
+	UserDetailFilePath string     `yaml:"userDetailFilePath"`
+	LDAP               LDAPConfig `yaml:"ldap"`
+	// HtpasswdFilePath and GroupFilePath - Apache htpasswd file and group file(group: user1 user2) of the htpasswd source
+	HtpasswdFilePath string `yaml:"htpasswdFilePath"`
+	GroupFilePath    string `yaml:"groupFilePath"`
+	// ReloadInterval - How often the user files are checked for changes. Default 10s, 0 disables
+	ReloadInterval string `yaml:"reloadInterval"`
+}
+
+// ReloadStatus - Outcome of the last reload of the user files, exposed in health
+type ReloadStatus struct {
+	Source      string    `json:"source"`
+	Files       []string  `json:"files"`
+	Users       int       `json:"users"`
+	LastReload  time.Time `json:"lastReload,omitempty"`
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// HTTPServerConfig - Timeouts(durations like 30s) and limits of the HTTP server, see net/http.Server
+type HTTPServerConfig struct {
+	ReadTimeout       string `yaml:"readTimeout"`
+	ReadHeaderTimeout string `yaml:"readHeaderTimeout"`
+	WriteTimeout      string `yaml:"writeTimeout"`
+	IdleTimeout       string `yaml:"idleTimeout"`
+	MaxHeaderBytes    int    `yaml:"maxHeaderBytes"`
+	// ShutdownTimeout - How long in-flight requests are waited for on SIGTERM/SIGINT
+	ShutdownTimeout string `yaml:"shutdownTimeout"`
+}
+
+// TLSConfig - Settings of the HTTPS server
+type TLSConfig struct {
+	// CertFile, KeyFile - PEM encoded serving certificate and key, e.g. a secret mounted by cert-manager.
+	// Reloaded when the files change. The env variables AUTH_CERT_TLS_CRT and AUTH_CERT_TLS_KEY are used if not set
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ReloadInterval - How often the certificate files are checked for changes, default 1m
+	ReloadInterval string `yaml:"reloadInterval"`
+	// SelfSigned - Generate a CA and serving certificate if no certificate is configured
+	SelfSigned SelfSignedConfig `yaml:"selfSigned"`
+	// ClientAuth - none, request or require-and-verify
+	ClientAuth   string `yaml:"clientAuth"`
+	ClientCAFile string `yaml:"clientCAFile"`
+	// AllowedClientNames - Patterns(path.Match) of the common name or a SAN of accepted client certificates
+	AllowedClientNames []string `yaml:"allowedClientNames"`
+	// MinVersion - 1.2 or 1.3, default 1.2
+	MinVersion string `yaml:"minVersion"`
+	// CipherSuites - TLS 1.2 cipher suites by name, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go defaults if empty
+	CipherSuites []string `yaml:"cipherSuites"`
+}
+
+// SelfSignedConfig - Bootstrap mode generating a CA and a serving certificate signed by it in Directory.
+// Both are reused on restart, the serving certificate is issued again when it expires soon or Hosts change.
+type SelfSignedConfig struct {
+	Directory string `yaml:"directory"`
+	// Hosts - DNS names and IP addresses of the serving certificate
+	Hosts []string `yaml:"hosts"`
+	// Validity - Lifetime of the serving certificate, default 8760h. The CA is valid for 10 years
+	Validity string `yaml:"validity"`
+	// ServerURL - URL the kube-apiserver reaches the webhook at, e.g. https://192.168.1.35:8443. Used in the generated kubeconfigs
+	ServerURL string `yaml:"serverURL"`
+}
+
+// CertificateStatus - The serving certificate in use, exposed in health
+type CertificateStatus struct {
+	CertFile    string    `json:"certFile,omitempty"`
+	Subject     string    `json:"subject"`
+	NotAfter    time.Time `json:"notAfter"`
+	LastReload  time.Time `json:"lastReload"`
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// WebhookCallerConfig - Callers of /v0/authenticate and /v0/authorize must present one of the tokens
+// as bearer token or a client certificate signed by the CA. Anyone can call them if neither is set.
+type WebhookCallerConfig struct {
+	Tokens       []WebhookCallerToken `yaml:"tokens"`
+	ClientCAFile string               `yaml:"clientCAFile"`
+	// ClientNames - Patterns(path.Match) of the common name or a SAN the client certificate must have, any if empty
+	ClientNames []string `yaml:"clientNames"`
+	// AllowUnauthenticated - Start without tokens and clientCAFile, anyone can call the webhook endpoints
+	AllowUnauthenticated bool `yaml:"allowUnauthenticated"`
+}
+
+// WebhookCallerToken - Bearer token of a cluster, the token field of the user in the webhook kubeconfig
+type WebhookCallerToken struct {
+	Cluster   string `yaml:"cluster"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+}
+
+// RevocationList - Revoked tokens, saved in authConfig.revocationFilePath and listed by the admin API
+type RevocationList struct {
+	Tokens []RevokedToken `json:"tokens"`
+	Users  []RevokedUser  `json:"users"`
+}
+
+// RevokedToken - A token revoked by its jti, kept until the token expires
+type RevokedToken struct {
+	ID        string    `json:"jti"`
+	Username  string    `json:"username,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// RevokedUser - All tokens of the user issued up to RevokedAt are revoked, kept until the last of them expires
+type RevokedUser struct {
+	Username  string    `json:"username"`
+	RevokedAt time.Time `json:"revokedAt"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// Audit events
+const (
+	AuditLogin               = "login"
+	AuditTokenReview         = "tokenReview"
+	AuditSubjectAccessReview = "subjectAccessReview"
+)
+
+// AuditRecord - A line of the audit log. Hash is the sha256 of PrevHash and the record without Hash
+type AuditRecord struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Outcome   string    `json:"outcome"`
+	User      string    `json:"user,omitempty"`
+	Groups    []string  `json:"groups,omitempty"`
+	SourceIP  string    `json:"sourceIP,omitempty"`
+	Caller    string    `json:"caller,omitempty"`
+	RequestID string    `json:"requestID,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	// ResourceAttributes, NonResourceAttributes - What a SubjectAccessReview asked for
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	PrevHash              string                 `json:"prevHash"`
+	Hash                  string                 `json:"hash,omitempty"`
+}
+
+// LoginLockout - Failed logins of a username or a source IP, listed by the admin API
+type LoginLockout struct {
+	Username     string    `json:"username,omitempty"`
+	SourceIP     string    `json:"sourceIP,omitempty"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"lastFailure"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+	LockedOut    bool      `json:"lockedOut"`
+}
+
+// ClearLockoutRequest is the body of the admin API clearing lockouts. All clears every username and source IP
+type ClearLockoutRequest struct {
+	Username string `json:"username,omitempty"`
+	SourceIP string `json:"sourceIP,omitempty"`
+	All      bool   `json:"all,omitempty"`
+}
+
+// RevokeRequest is the body of the admin revoke APIs
+type RevokeRequest struct {
+	Username string `json:"username,omitempty"`
+	ID       string `json:"jti,omitempty"`
+}
+
+// LDAPConfig - LDAP/Active Directory server used by the ldap user source.
+// Templates and filters can use the placeholders {username} and {dn}(DN of the user, group filter only)
+type LDAPConfig struct {
+	URL                string `yaml:"url"`
+	StartTLS           bool   `yaml:"startTLS"`
+	CAFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	Timeout            string `yaml:"timeout"`
+	BindDNTemplate     string `yaml:"bindDNTemplate"`
+	// LookupBindDN and LookupBindPassword - Service account used to look up users without their password
+	LookupBindDN       string `yaml:"lookupBindDN"`
+	LookupBindPassword string `yaml:"lookupBindPassword"`
+	BaseDN             string `yaml:"baseDN"`
+	UserFilter         string `yaml:"userFilter"`
+	UsernameAttribute  string `yaml:"usernameAttribute"`
+	UIDAttribute       string `yaml:"uidAttribute"`
+	EmailAttribute     string `yaml:"emailAttribute"`
+	GroupAttribute     string `yaml:"groupAttribute"`
+	GroupBaseDN        string `yaml:"groupBaseDN"`
+	GroupFilter        string `yaml:"groupFilter"`
+	GroupNameAttribute string `yaml:"groupNameAttribute"`
 }
 
 //AuthResponse ...
@@ -43,8 +341,15 @@ type Token struct {
 
 //V1Token ...
 type V1Token struct {
-	Token  string `json:"token,omitempty"`
-	Expiry int64  `json:"expiry,omitempty"`
+	Token         string `json:"token,omitempty"`
+	Expiry        int64  `json:"expiry,omitempty"`
+	RefreshToken  string `json:"refreshToken,omitempty"`
+	RefreshExpiry int64  `json:"refreshExpiry,omitempty"`
+}
+
+// RefreshRequest is the body of /v0/token/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 //RawAuthResponse ...
@@ -77,11 +382,33 @@ type UserCredentials struct {
 
 // JWTClaimsJSON is used for decoding an incoming JSON JWT payload to the /authenticate API
 type JWTClaimsJSON struct {
-	Iat      int64    `json:"iat"`
-	UID      string   `json:"uid"`
-	Username string   `json:"username"`
-	Expiry   int64    `json:"exp"`
-	Groups   []string `json:"groups"`
+	Iat      int64        `json:"iat"`
+	UID      string       `json:"uid"`
+	Username string       `json:"username"`
+	Expiry   int64        `json:"exp"`
+	Groups   []string     `json:"groups"`
+	Issuer   string       `json:"iss"`
+	Subject  string       `json:"sub"`
+	Audience ClaimStrings `json:"aud"`
+	ID       string       `json:"jti"`
+}
+
+// ClaimStrings is a claim like aud that can be a single string or an array of strings
+type ClaimStrings []string
+
+// UnmarshalJSON accepts both a string and an array of strings
+func (c *ClaimStrings) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*c = ClaimStrings{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*c = multiple
+	return nil
 }
 
 // Valid so that JWTClaimsJSON satisfies the jwt.Claims interface
@@ -103,8 +430,10 @@ func (c JWTClaimsJSON) Valid() error {
 
 //Status indicates if user is authenticated or not
 type Status struct {
-	Authenticated *bool `json:"authenticated,omitempty"`
-	User          *User `json:"user,omitempty"`
+	Authenticated *bool    `json:"authenticated,omitempty"`
+	User          *User    `json:"user,omitempty"`
+	Audiences     []string `json:"audiences,omitempty"`
+	Error         string   `json:"error,omitempty"`
 }
 
 //Request maps the incoming auth request from api-server
@@ -116,7 +445,8 @@ type Request struct {
 
 //Spec maps to the bearer token send by api-server
 type Spec struct {
-	Token string `json:"token,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	Audiences []string `json:"audiences,omitempty"`
 }
 
 //Authorization response
@@ -126,8 +456,54 @@ type AuthorizationResponse struct {
 	Status     *AuthorizationStatus `json:"status,omitempty"`
 }
 
+// AuthorizationStatus holds the decision of the authorization webhook
 type AuthorizationStatus struct {
-	Allowed bool   `json:"allowed,omitempty"`
-	Denied  bool   `json:"denied,omitempty"`
-	Reason  string `json:"reason,omitempty"`
+	Allowed         bool   `json:"allowed"`
+	Denied          bool   `json:"denied,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// AuthorizationRequest maps the incoming SubjectAccessReview from api-server
+type AuthorizationRequest struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Spec       *AuthorizationSpec `json:"spec,omitempty"`
+}
+
+// AuthorizationSpec holds the attributes of the request being authorized
+type AuthorizationSpec struct {
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                 `json:"user,omitempty"`
+	// Groups is sent as "groups" by authorization.k8s.io/v1 and as "group" by v1beta1
+	Groups []string            `json:"groups,omitempty"`
+	Group  []string            `json:"group,omitempty"`
+	UID    string              `json:"uid,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+// UserGroups returns the groups of the user irrespective of the api version of the request
+func (s AuthorizationSpec) UserGroups() []string {
+	if len(s.Groups) != 0 {
+		return s.Groups
+	}
+	return s.Group
+}
+
+// ResourceAttributes describes a request on a kubernetes resource
+type ResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// NonResourceAttributes describes a request on a non resource path like /healthz
+type NonResourceAttributes struct {
+	Path string `json:"path,omitempty"`
+	Verb string `json:"verb,omitempty"`
 }
@@ -12,4 +12,6 @@ type UserDetails struct {
 	Email    string   `yaml:"email"`
 	UID      string   `yaml:"uid"`
 	Groups   []string `yaml:"groups"`
+	// TokenTTL - Lifetime of the tokens of the user, e.g. 720h for a CI robot. Limited by authConfig.maxTokenTTL
+	TokenTTL string `yaml:"tokenTTL"`
 }
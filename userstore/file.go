@@ -0,0 +1,105 @@
+package userstore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/password"
+	"gopkg.in/yaml.v2"
+)
+
+// FileStore reads the users from a yaml file. Refer config/user_details.yaml
+type FileStore struct {
+	path  string
+	users *fileUsers
+}
+
+// NewFileStore returns a store for the user details file
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("authConfig.v0.userDetailFilePath is mandatory for file user source")
+	}
+	s := &FileStore{path: path}
+	s.users = newFileUsers(SourceFile, []string{path}, s.parse)
+	return s, nil
+}
+
+// Lookup returns the details of the user
+func (s *FileStore) Lookup(userName string) (types.UserDetails, error) {
+	users, err := s.users.get()
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	userDtl, ok := users[userName]
+	if !ok {
+		return types.UserDetails{}, ErrUserNotFound
+	}
+	if userDtl.UserName == "" {
+		userDtl.UserName = userName
+	}
+	return userDtl, nil
+}
+
+// Authenticate verifies the password of the user. Refer util/password for the supported hashes
+func (s *FileStore) Authenticate(userName, pwd string) (types.UserDetails, error) {
+	start := time.Now()
+	userDtl, err := s.Lookup(userName)
+	if err == ErrUserNotFound {
+		password.VerifyDummy(pwd)
+		log.Debugf("Login of unknown user %s", userName)
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	match, err := password.Verify(userDtl.Password, pwd)
+	if err != nil {
+		log.Errorf("Unable to verify password of user %s : %v", userName, err)
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	if !match {
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	return userDtl, nil
+}
+
+// Status returns the outcome of the last reload of the file
+func (s *FileStore) Status() types.ReloadStatus {
+	return s.users.Status()
+}
+
+func (s *FileStore) parse() (map[string]types.UserDetails, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var userConf types.UserDetailsConfig
+	if yamlErr := yaml.Unmarshal(data, &userConf); yamlErr != nil {
+		return nil, yamlErr
+	}
+	for userName, userDtl := range userConf.UserDetails {
+		if userDtl.Password == "" {
+			return nil, fmt.Errorf("User %s in %s has no password", userName, s.path)
+		}
+		if userDtl.TokenTTL != "" {
+			if _, err := time.ParseDuration(userDtl.TokenTTL); err != nil {
+				return nil, fmt.Errorf("User %s in %s has an invalid tokenTTL : %v", userName, s.path, err)
+			}
+		}
+		if password.IsHashed(userDtl.Password) {
+			if err := password.Check(userDtl.Password); err != nil {
+				return nil, fmt.Errorf("User %s in %s has an invalid password hash : %v", userName, s.path, err)
+			}
+		} else {
+			log.Infof("Password of user %s in %s is not hashed. Use the hash-password command to generate a hash", userName, s.path)
+		}
+	}
+	if userConf.UserDetails == nil {
+		userConf.UserDetails = map[string]types.UserDetails{}
+	}
+	return userConf.UserDetails, nil
+}
@@ -0,0 +1,29 @@
+package userstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreRejectsMalformedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user_details.yaml")
+	data := `userDetails:
+  alice:
+    password: "$pbkdf2-sha256$1$c2FsdA$"
+    groups: [admins]
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if status := store.Status(); status.LastError == "" || status.Users != 0 {
+		t.Errorf("the file with a malformed hash was loaded: %+v", status)
+	}
+	if _, err := store.Authenticate("alice", "anything"); err == nil {
+		t.Error("Authenticate succeeded with an empty PBKDF2 hash")
+	}
+}
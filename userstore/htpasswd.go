@@ -0,0 +1,157 @@
+package userstore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/password"
+)
+
+// HtpasswdStore reads the users from an Apache htpasswd file and their groups from an Apache group file
+type HtpasswdStore struct {
+	path      string
+	groupPath string
+	users     *fileUsers
+}
+
+// NewHtpasswdStore returns a store for the htpasswd and group files
+func NewHtpasswdStore(path string, groupPath string) (*HtpasswdStore, error) {
+	if path == "" {
+		return nil, errors.New("authConfig.v0.htpasswdFilePath is mandatory for htpasswd user source")
+	}
+	s := &HtpasswdStore{path: path, groupPath: groupPath}
+	files := []string{path}
+	if groupPath != "" {
+		files = append(files, groupPath)
+	}
+	s.users = newFileUsers(SourceHtpasswd, files, s.parse)
+	return s, nil
+}
+
+// Lookup returns the details of the user
+func (s *HtpasswdStore) Lookup(userName string) (types.UserDetails, error) {
+	users, err := s.users.get()
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	userDtl, ok := users[userName]
+	if !ok {
+		return types.UserDetails{}, ErrUserNotFound
+	}
+	return userDtl, nil
+}
+
+// Authenticate verifies the password of the user against the bcrypt, SHA1 or APR1 hash in the htpasswd file
+func (s *HtpasswdStore) Authenticate(userName, pwd string) (types.UserDetails, error) {
+	start := time.Now()
+	userDtl, err := s.Lookup(userName)
+	if err == ErrUserNotFound {
+		password.VerifyDummy(pwd)
+		log.Debugf("Login of unknown user %s", userName)
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	if !password.IsHashed(userDtl.Password) {
+		log.Errorf("Unsupported hash for user %s in %s, use bcrypt(htpasswd -B), SHA1(-s) or MD5(-m)", userName, s.path)
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	match, err := password.Verify(userDtl.Password, pwd)
+	if err != nil {
+		log.Errorf("Unable to verify password of user %s : %v", userName, err)
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	if !match {
+		return types.UserDetails{}, s.users.failed(start)
+	}
+	return userDtl, nil
+}
+
+// Status returns the outcome of the last reload of the files
+func (s *HtpasswdStore) Status() types.ReloadStatus {
+	return s.users.Status()
+}
+
+func (s *HtpasswdStore) parse() (map[string]types.UserDetails, error) {
+	users, err := readHtpasswd(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if s.groupPath != "" {
+		groups, err := readGroupFile(s.groupPath)
+		if err != nil {
+			return nil, err
+		}
+		for userName, userGroups := range groups {
+			if userDtl, ok := users[userName]; ok {
+				userDtl.Groups = userGroups
+				users[userName] = userDtl
+			}
+		}
+	}
+	return users, nil
+}
+
+// readHtpasswd parses lines of user:hash. Empty lines and lines starting with # are skipped
+func readHtpasswd(path string) (map[string]types.UserDetails, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]types.UserDetails)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.Index(line, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("%s:%d is not in user:hash format", path, lineNo)
+		}
+		userName := line[:sep]
+		if password.IsHashed(line[sep+1:]) {
+			if err := password.Check(line[sep+1:]); err != nil {
+				return nil, fmt.Errorf("%s:%d has an invalid hash for user %s : %v", path, lineNo, userName, err)
+			}
+		}
+		users[userName] = types.UserDetails{
+			UserName: userName,
+			Password: line[sep+1:],
+		}
+	}
+	return users, scanner.Err()
+}
+
+// readGroupFile parses lines of group: user1 user2 and returns the groups of each user
+func readGroupFile(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.Index(line, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("%s:%d is not in group: user1 user2 format", path, lineNo)
+		}
+		group := strings.TrimSpace(line[:sep])
+		for _, userName := range strings.Fields(line[sep+1:]) {
+			groups[userName] = append(groups[userName], group)
+		}
+	}
+	return groups, scanner.Err()
+}
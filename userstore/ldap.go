@@ -0,0 +1,328 @@
+package userstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultLDAPTimeout        = 10 * time.Second
+	defaultLDAPUserFilter     = "(uid={username})"
+	defaultLDAPEmailAttribute = "mail"
+	defaultLDAPGroupAttribute = "memberOf"
+	defaultLDAPGroupName      = "cn"
+)
+
+// ldapConn is the part of *ldap.Conn used by the store, so that tests can use an in-process stand-in
+type ldapConn interface {
+	Bind(username, password string) error
+	StartTLS(config *tls.Config) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// LDAPStore authenticates users by binding to an LDAP/Active Directory server with their credentials
+type LDAPStore struct {
+	config    types.LDAPConfig
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	dial      func(url string, tlsConfig *tls.Config, timeout time.Duration) (ldapConn, error)
+
+	bindMutex sync.Mutex
+	// bindTime is the running average of the time a login takes up to the result of the user bind
+	bindTime time.Duration
+}
+
+// bindTimeWeight is the weight of the latest login in the running average of bindTime
+const bindTimeWeight = 0.1
+
+// NewLDAPStore returns a store for the LDAP server in the config
+func NewLDAPStore(config types.LDAPConfig) (*LDAPStore, error) {
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("authConfig.v0.ldap.url and authConfig.v0.ldap.baseDN are mandatory for ldap user source")
+	}
+	if config.BindDNTemplate == "" && config.LookupBindDN == "" {
+		return nil, errors.New("Either authConfig.v0.ldap.bindDNTemplate or authConfig.v0.ldap.lookupBindDN must be configured")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = defaultLDAPUserFilter
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = defaultLDAPEmailAttribute
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if config.GroupNameAttribute == "" {
+		config.GroupNameAttribute = defaultLDAPGroupName
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+
+	store := &LDAPStore{
+		config:  config,
+		timeout: defaultLDAPTimeout,
+		dial:    dialLDAP,
+	}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid authConfig.v0.ldap.timeout : %v", err)
+		}
+		store.timeout = timeout
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		caData, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("No certificates found in %s", config.CAFile)
+		}
+	}
+	store.tlsConfig = tlsConfig
+	return store, nil
+}
+
+// Authenticate binds to the server as the user and reads the user's details and groups
+func (s *LDAPStore) Authenticate(userName, password string) (types.UserDetails, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if userName == "" || password == "" {
+		return types.UserDetails{}, ErrInvalidCredentials
+	}
+	start := time.Now()
+	conn, err := s.connect()
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	defer conn.Close()
+
+	bindDN := expand(s.config.BindDNTemplate, userName, "", escapeDN)
+	if bindDN == "" {
+		// Search the DN of the user with the lookup account and bind with it
+		if err := conn.Bind(s.config.LookupBindDN, s.config.LookupBindPassword); err != nil {
+			log.Errorf("LDAP lookup bind as %s failed : %v", s.config.LookupBindDN, err)
+			return types.UserDetails{}, err
+		}
+		entry, err := s.searchUser(conn, userName)
+		if err == ErrUserNotFound {
+			log.Debugf("Login of unknown LDAP user %s", userName)
+			return types.UserDetails{}, s.unknownUser(start)
+		}
+		if err != nil {
+			return types.UserDetails{}, err
+		}
+		bindDN = entry.DN
+	}
+	err = conn.Bind(bindDN, password)
+	s.observeBind(time.Since(start))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return types.UserDetails{}, ErrInvalidCredentials
+		}
+		log.Errorf("LDAP bind as %s failed : %v", bindDN, err)
+		return types.UserDetails{}, err
+	}
+	return s.userDetails(conn, userName)
+}
+
+// Lookup reads the details of the user using the lookup account
+func (s *LDAPStore) Lookup(userName string) (types.UserDetails, error) {
+	if s.config.LookupBindDN == "" {
+		return types.UserDetails{}, errors.New("authConfig.v0.ldap.lookupBindDN is needed to look up LDAP users without their password")
+	}
+	conn, err := s.connect()
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	defer conn.Close()
+	if err := conn.Bind(s.config.LookupBindDN, s.config.LookupBindPassword); err != nil {
+		log.Errorf("LDAP lookup bind as %s failed : %v", s.config.LookupBindDN, err)
+		return types.UserDetails{}, err
+	}
+	return s.userDetails(conn, userName)
+}
+
+// observeBind adds the time of a login up to the result of the user bind to the running average
+func (s *LDAPStore) observeBind(elapsed time.Duration) {
+	s.bindMutex.Lock()
+	defer s.bindMutex.Unlock()
+	if s.bindTime == 0 {
+		s.bindTime = elapsed
+		return
+	}
+	s.bindTime += time.Duration(bindTimeWeight * float64(elapsed-s.bindTime))
+}
+
+// unknownUser returns ErrInvalidCredentials once the login started at start took as long as a user bind does
+// on average. An unknown user found by the lookup account is not bound, the response time must not tell it apart
+func (s *LDAPStore) unknownUser(start time.Time) error {
+	s.bindMutex.Lock()
+	bindTime := s.bindTime
+	s.bindMutex.Unlock()
+	if wait := bindTime - time.Since(start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return ErrInvalidCredentials
+}
+
+func (s *LDAPStore) connect() (ldapConn, error) {
+	conn, err := s.dial(s.config.URL, s.tlsConfig, s.timeout)
+	if err != nil {
+		log.Errorf("Unable to connect to LDAP server %s : %v", s.config.URL, err)
+		return nil, err
+	}
+	if s.config.StartTLS {
+		if err := conn.StartTLS(startTLSConfig(s.tlsConfig, s.config.URL)); err != nil {
+			conn.Close()
+			log.Errorf("LDAP StartTLS with %s failed : %v", s.config.URL, err)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *LDAPStore) searchUser(conn ldapConn, userName string) (*ldap.Entry, error) {
+	attributes := []string{s.config.EmailAttribute, s.config.GroupAttribute}
+	for _, attribute := range []string{s.config.UsernameAttribute, s.config.UIDAttribute} {
+		if attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		s.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(s.timeout.Seconds()), false,
+		expand(s.config.UserFilter, userName, "", ldap.EscapeFilter), attributes, nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrUserNotFound
+		}
+		log.Errorf("LDAP search of user %s failed : %v", userName, err)
+		return nil, err
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("LDAP user filter matched more than one entry for %s", userName)
+	}
+}
+
+func (s *LDAPStore) userDetails(conn ldapConn, userName string) (types.UserDetails, error) {
+	entry, err := s.searchUser(conn, userName)
+	if err != nil {
+		return types.UserDetails{}, err
+	}
+	userDtl := types.UserDetails{
+		UserName: userName,
+		Email:    entry.GetAttributeValue(s.config.EmailAttribute),
+	}
+	if s.config.UsernameAttribute != "" && entry.GetAttributeValue(s.config.UsernameAttribute) != "" {
+		userDtl.UserName = entry.GetAttributeValue(s.config.UsernameAttribute)
+	}
+	if s.config.UIDAttribute != "" {
+		userDtl.UID = entry.GetAttributeValue(s.config.UIDAttribute)
+	}
+
+	seen := map[string]bool{}
+	for _, groupDN := range entry.GetAttributeValues(s.config.GroupAttribute) {
+		if name := groupName(groupDN, s.config.GroupNameAttribute); name != "" && !seen[name] {
+			seen[name] = true
+			userDtl.Groups = append(userDtl.Groups, name)
+		}
+	}
+	if s.config.GroupFilter != "" {
+		result, err := conn.Search(ldap.NewSearchRequest(
+			s.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(s.timeout.Seconds()), false,
+			expand(s.config.GroupFilter, userName, entry.DN, ldap.EscapeFilter), []string{s.config.GroupNameAttribute}, nil))
+		if err != nil {
+			log.Errorf("LDAP group search of user %s failed : %v", userName, err)
+			return types.UserDetails{}, err
+		}
+		for _, group := range result.Entries {
+			if name := group.GetAttributeValue(s.config.GroupNameAttribute); name != "" && !seen[name] {
+				seen[name] = true
+				userDtl.Groups = append(userDtl.Groups, name)
+			}
+		}
+	}
+	return userDtl, nil
+}
+
+// groupName returns the value of the nameAttribute RDN of a group DN like cn=admins,ou=groups,dc=example,dc=org
+func groupName(groupDN string, nameAttribute string) string {
+	dn, err := ldap.ParseDN(groupDN)
+	if err != nil || len(dn.RDNs) == 0 {
+		return groupDN
+	}
+	for _, attribute := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attribute.Type, nameAttribute) {
+			return attribute.Value
+		}
+	}
+	return groupDN
+}
+
+// expand replaces the {username} and {dn} placeholders with escaped values
+func expand(template string, userName string, dn string, escape func(string) string) string {
+	return strings.NewReplacer("{username}", escape(userName), "{dn}", escape(dn)).Replace(template)
+}
+
+// escapeDN escapes a value for use in a DN as described in RFC 4514
+func escapeDN(value string) string {
+	var sb strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`"+,;<>\`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r == 0:
+			sb.WriteString(`\00`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// startTLSConfig sets the server name verified by StartTLS to the host of the URL. Unlike ldaps, where
+// tls.DialWithDialer sets it, StartTLS uses the config as it is
+func startTLSConfig(tlsConfig *tls.Config, ldapURL string) *tls.Config {
+	if tlsConfig.ServerName != "" {
+		return tlsConfig
+	}
+	config := tlsConfig.Clone()
+	if u, err := url.Parse(ldapURL); err == nil {
+		config.ServerName = u.Hostname()
+	}
+	return config
+}
+
+func dialLDAP(url string, tlsConfig *tls.Config, timeout time.Duration) (ldapConn, error) {
+	conn, err := ldap.DialURL(url, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	return conn, nil
+}
@@ -0,0 +1,215 @@
+package userstore
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/types"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testLDAPURL    = "ldap://ldap.example.org:389"
+	testBaseDN     = "dc=example,dc=org"
+	testAliceDN    = "uid=alice,ou=people,dc=example,dc=org"
+	testLookupDN   = "cn=lookup,dc=example,dc=org"
+	testLookupPass = "lookup-secret"
+)
+
+// fakeLDAP is an in-process LDAP server stand-in. Binds succeed with the passwords of the DNs,
+// searches return the entries registered for the filter
+type fakeLDAP struct {
+	passwords map[string]string
+	searches  map[string][]*ldap.Entry
+	// bindDelay is how long the binds of users, not of the lookup account, take
+	bindDelay time.Duration
+
+	startTLS *tls.Config
+	calls    []string
+	closed   bool
+}
+
+func (f *fakeLDAP) Bind(username, password string) error {
+	f.calls = append(f.calls, "bind "+username)
+	if username != testLookupDN {
+		time.Sleep(f.bindDelay)
+	}
+	if expected, ok := f.passwords[username]; !ok || password == "" || expected != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (f *fakeLDAP) StartTLS(config *tls.Config) error {
+	f.calls = append(f.calls, "starttls")
+	f.startTLS = config
+	return nil
+}
+
+func (f *fakeLDAP) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.calls = append(f.calls, "search "+searchRequest.Filter)
+	return &ldap.SearchResult{Entries: f.searches[searchRequest.Filter]}, nil
+}
+
+func (f *fakeLDAP) Close() {
+	f.closed = true
+}
+
+func newFakeLDAP() *fakeLDAP {
+	return &fakeLDAP{
+		passwords: map[string]string{
+			testAliceDN:  "alice-secret",
+			testLookupDN: testLookupPass,
+		},
+		searches: map[string][]*ldap.Entry{
+			"(uid=alice)": {ldap.NewEntry(testAliceDN, map[string][]string{
+				"mail":     {"alice@example.org"},
+				"memberOf": {"cn=admins,ou=groups,dc=example,dc=org", "cn=dev,ou=groups,dc=example,dc=org"},
+			})},
+			"(member=" + testAliceDN + ")": {
+				ldap.NewEntry("cn=dev,ou=groups,dc=example,dc=org", map[string][]string{"cn": {"dev"}}),
+				ldap.NewEntry("cn=ops,ou=groups,dc=example,dc=org", map[string][]string{"cn": {"ops"}}),
+			},
+		},
+	}
+}
+
+func newTestLDAPStore(t *testing.T, config types.LDAPConfig, server *fakeLDAP) *LDAPStore {
+	t.Helper()
+	config.URL = testLDAPURL
+	config.BaseDN = testBaseDN
+	store, err := NewLDAPStore(config)
+	if err != nil {
+		t.Fatalf("NewLDAPStore: %v", err)
+	}
+	store.dial = func(url string, tlsConfig *tls.Config, timeout time.Duration) (ldapConn, error) {
+		return server, nil
+	}
+	return store
+}
+
+func TestLDAPBindTemplate(t *testing.T) {
+	server := newFakeLDAP()
+	store := newTestLDAPStore(t, types.LDAPConfig{BindDNTemplate: "uid={username},ou=people,dc=example,dc=org"}, server)
+
+	userDtl, err := store.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if userDtl.UserName != "alice" || userDtl.Email != "alice@example.org" {
+		t.Errorf("unexpected user details %+v", userDtl)
+	}
+	if expected := []string{"admins", "dev"}; !reflect.DeepEqual(userDtl.Groups, expected) {
+		t.Errorf("groups from memberOf = %v, expected %v", userDtl.Groups, expected)
+	}
+	if expected := []string{"bind " + testAliceDN, "search (uid=alice)"}; !reflect.DeepEqual(server.calls, expected) {
+		t.Errorf("calls = %v, expected %v", server.calls, expected)
+	}
+	if !server.closed {
+		t.Error("connection not closed")
+	}
+}
+
+func TestLDAPLookupBind(t *testing.T) {
+	server := newFakeLDAP()
+	store := newTestLDAPStore(t, types.LDAPConfig{LookupBindDN: testLookupDN, LookupBindPassword: testLookupPass}, server)
+
+	userDtl, err := store.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if expected := []string{"admins", "dev"}; !reflect.DeepEqual(userDtl.Groups, expected) {
+		t.Errorf("groups = %v, expected %v", userDtl.Groups, expected)
+	}
+	expected := []string{"bind " + testLookupDN, "search (uid=alice)", "bind " + testAliceDN, "search (uid=alice)"}
+	if !reflect.DeepEqual(server.calls, expected) {
+		t.Errorf("calls = %v, expected %v", server.calls, expected)
+	}
+
+	userDtl, err = store.Lookup("alice")
+	if err != nil || userDtl.Email != "alice@example.org" {
+		t.Errorf("Lookup = %+v, %v", userDtl, err)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   types.LDAPConfig
+		userName string
+		password string
+	}{
+		{"wrong password with template", types.LDAPConfig{BindDNTemplate: "uid={username},ou=people,dc=example,dc=org"}, "alice", "wrong"},
+		{"wrong password with lookup", types.LDAPConfig{LookupBindDN: testLookupDN, LookupBindPassword: testLookupPass}, "alice", "wrong"},
+		{"unknown user with lookup", types.LDAPConfig{LookupBindDN: testLookupDN, LookupBindPassword: testLookupPass}, "bob", "secret"},
+		{"empty password", types.LDAPConfig{BindDNTemplate: "uid={username},ou=people,dc=example,dc=org"}, "alice", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestLDAPStore(t, test.config, newFakeLDAP())
+			if _, err := store.Authenticate(test.userName, test.password); err != ErrInvalidCredentials {
+				t.Errorf("Authenticate error = %v, expected %v", err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+func TestLDAPUnknownUserTiming(t *testing.T) {
+	server := newFakeLDAP()
+	server.bindDelay = 30 * time.Millisecond
+	store := newTestLDAPStore(t, types.LDAPConfig{LookupBindDN: testLookupDN, LookupBindPassword: testLookupPass}, server)
+
+	if _, err := store.Authenticate("alice", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("Authenticate with a wrong password: %v", err)
+	}
+	start := time.Now()
+	if _, err := store.Authenticate("bob", "secret"); err != ErrInvalidCredentials {
+		t.Fatalf("Authenticate of an unknown user: %v", err)
+	}
+	// The unknown user is not bound, the failure is padded to the time of the user bind
+	if elapsed := time.Since(start); elapsed < server.bindDelay {
+		t.Errorf("unknown user answered in %s, a user bind takes %s", elapsed, server.bindDelay)
+	}
+}
+
+func TestLDAPGroupFilter(t *testing.T) {
+	server := newFakeLDAP()
+	store := newTestLDAPStore(t, types.LDAPConfig{
+		BindDNTemplate: "uid={username},ou=people,dc=example,dc=org",
+		GroupFilter:    "(member={dn})",
+	}, server)
+
+	userDtl, err := store.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	// dev is both in memberOf and found by the filter
+	if expected := []string{"admins", "dev", "ops"}; !reflect.DeepEqual(userDtl.Groups, expected) {
+		t.Errorf("groups = %v, expected %v", userDtl.Groups, expected)
+	}
+}
+
+func TestLDAPStartTLS(t *testing.T) {
+	server := newFakeLDAP()
+	store := newTestLDAPStore(t, types.LDAPConfig{
+		BindDNTemplate: "uid={username},ou=people,dc=example,dc=org",
+		StartTLS:       true,
+	}, server)
+
+	if _, err := store.Authenticate("alice", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if len(server.calls) == 0 || server.calls[0] != "starttls" {
+		t.Fatalf("StartTLS not done before the bind, calls = %v", server.calls)
+	}
+	if server.startTLS == nil || server.startTLS.ServerName != "ldap.example.org" {
+		t.Errorf("StartTLS config without the server name of the URL: %+v", server.startTLS)
+	}
+	if store.tlsConfig.ServerName != "" {
+		t.Errorf("the shared TLS config was modified")
+	}
+}
@@ -0,0 +1,156 @@
+package userstore
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
+	"github.com/dinumathai/auth-webhook-sample/util/password"
+)
+
+const defaultReloadInterval = 10 * time.Second
+
+// fileUsers holds the users parsed from files. The files are parsed again when they change or on SIGHUP.
+// A new copy is swapped in only if it parses, otherwise the previous good copy is kept.
+type fileUsers struct {
+	source string
+	files  []string
+	parse  func() (map[string]types.UserDetails, error)
+
+	mutex   sync.RWMutex
+	users   map[string]types.UserDetails
+	status  types.ReloadStatus
+	modTime time.Time
+	// failureTime is the verification time of the slowest password hash of the users
+	failureTime time.Duration
+}
+
+func newFileUsers(source string, files []string, parse func() (map[string]types.UserDetails, error)) *fileUsers {
+	fu := &fileUsers{
+		source: source,
+		files:  files,
+		parse:  parse,
+		status: types.ReloadStatus{Source: source, Files: files},
+	}
+	fu.reload("startup")
+	return fu
+}
+
+// get returns the current users. Fails only if the files never loaded successfully
+func (fu *fileUsers) get() (map[string]types.UserDetails, error) {
+	fu.mutex.RLock()
+	defer fu.mutex.RUnlock()
+	if fu.users == nil {
+		return nil, &reloadError{fu.status.LastError}
+	}
+	return fu.users, nil
+}
+
+// Status returns the outcome of the last reload
+func (fu *fileUsers) Status() types.ReloadStatus {
+	fu.mutex.RLock()
+	defer fu.mutex.RUnlock()
+	return fu.status
+}
+
+// watch reloads the files when their modification time changes and on SIGHUP
+func (fu *fileUsers) watch(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	} else {
+		log.Infof("Change check of %s user files disabled, reload with SIGHUP", fu.source)
+	}
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				fu.reload("SIGHUP")
+			case <-tick:
+				if !fu.latestModTime().Equal(fu.lastModTime()) {
+					fu.reload("file change")
+				}
+			}
+		}
+	}()
+}
+
+func (fu *fileUsers) reload(trigger string) {
+	modTime := fu.latestModTime()
+	users, err := fu.parse()
+	var failureTime time.Duration
+	if err == nil {
+		passwords := make([]string, 0, len(users))
+		for _, userDtl := range users {
+			passwords = append(passwords, userDtl.Password)
+		}
+		failureTime = password.VerifyCost(passwords)
+	}
+
+	fu.mutex.Lock()
+	defer fu.mutex.Unlock()
+	now := time.Now()
+	fu.status.LastAttempt = now
+	// Remember the attempt so that a broken file is not parsed again until it changes
+	fu.modTime = modTime
+	metrics.UserStoreReload(fu.source, len(users), err)
+	if err != nil {
+		fu.status.LastError = err.Error()
+		if fu.users != nil {
+			log.Errorf("Reloading %s users on %s failed, keeping the previous %d users : %v", fu.source, trigger, len(fu.users), err)
+		} else {
+			log.Errorf("Loading %s users on %s failed : %v", fu.source, trigger, err)
+		}
+		return
+	}
+	fu.users = users
+	fu.failureTime = failureTime
+	fu.status.Users = len(users)
+	fu.status.LastReload = now
+	fu.status.LastError = ""
+	log.Infof("Loaded %d %s users on %s", len(users), fu.source, trigger)
+}
+
+// failed returns ErrInvalidCredentials once the login started at start took as long as verifying the slowest
+// password hash. Unknown users, wrong passwords and users with a faster hash or a plain text password
+// can then not be told apart by the response time
+func (fu *fileUsers) failed(start time.Time) error {
+	fu.mutex.RLock()
+	failureTime := fu.failureTime
+	fu.mutex.RUnlock()
+	if wait := failureTime - time.Since(start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return ErrInvalidCredentials
+}
+
+func (fu *fileUsers) lastModTime() time.Time {
+	fu.mutex.RLock()
+	defer fu.mutex.RUnlock()
+	return fu.modTime
+}
+
+func (fu *fileUsers) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range fu.files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+type reloadError struct {
+	cause string
+}
+
+func (e *reloadError) Error() string {
+	return "Users not loaded : " + e.cause
+}
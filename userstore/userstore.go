@@ -0,0 +1,73 @@
+package userstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/types"
+	"github.com/dinumathai/auth-webhook-sample/util/health"
+)
+
+// User sources selected by authConfig.v0.source
+const (
+	SourceFile     = "file"
+	SourceLDAP     = "ldap"
+	SourceHtpasswd = "htpasswd"
+)
+
+var (
+	// ErrUserNotFound is returned when the user is not present in the store
+	ErrUserNotFound = errors.New("User Not present")
+	// ErrInvalidCredentials is returned when the password does not match
+	ErrInvalidCredentials = errors.New("Invalid Credentials")
+)
+
+// UserStore is a source of users, their credentials and their groups
+type UserStore interface {
+	// Lookup returns the details of the user without verifying any credentials
+	Lookup(userName string) (types.UserDetails, error)
+	// Authenticate verifies the password and returns the details of the user. Unknown users get
+	// ErrInvalidCredentials too, the error must not tell whether the user exists
+	Authenticate(userName, password string) (types.UserDetails, error)
+}
+
+// New returns the user store selected by authConfig.v0.source. Stores backed by files
+// are reloaded when the files change and their reload status is added to health
+func New(config *types.ConfigMap) (UserStore, error) {
+	var users *fileUsers
+	var store UserStore
+	switch config.AuthConfig.V0.Source {
+	case "", SourceFile:
+		fileStore, err := NewFileStore(config.AuthConfig.V0.UserDetailFilePath)
+		if err != nil {
+			return nil, err
+		}
+		users, store = fileStore.users, fileStore
+	case SourceLDAP:
+		// Refresh looks the user up without the password, which needs the lookup account
+		if config.AuthConfig.RefreshTokenTTL != "" && config.AuthConfig.V0.LDAP.LookupBindDN == "" {
+			return nil, errors.New("authConfig.refreshTokenTTL needs authConfig.v0.ldap.lookupBindDN, refresh tokens could never be redeemed without it")
+		}
+		return NewLDAPStore(config.AuthConfig.V0.LDAP)
+	case SourceHtpasswd:
+		htpasswdStore, err := NewHtpasswdStore(config.AuthConfig.V0.HtpasswdFilePath, config.AuthConfig.V0.GroupFilePath)
+		if err != nil {
+			return nil, err
+		}
+		users, store = htpasswdStore.users, htpasswdStore
+	default:
+		return nil, fmt.Errorf("Unknown user source %q", config.AuthConfig.V0.Source)
+	}
+
+	interval := defaultReloadInterval
+	if config.AuthConfig.V0.ReloadInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(config.AuthConfig.V0.ReloadInterval); err != nil {
+			return nil, fmt.Errorf("Invalid authConfig.v0.reloadInterval : %v", err)
+		}
+	}
+	users.watch(interval)
+	health.RegisterStatus("userStore", func() interface{} { return users.Status() })
+	return store, nil
+}
@@ -3,6 +3,7 @@ package health
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/dinumathai/auth-webhook-sample/log"
@@ -11,14 +12,25 @@ import (
 
 //Response ...
 type Response struct {
-	BuildVersion string `json:"build.version"`
+	BuildVersion string                 `json:"build.version"`
+	Status       map[string]interface{} `json:"status,omitempty"`
 }
 
 var (
 	//Version ...
 	Version = "NotSet"
+
+	statusMutex     sync.RWMutex
+	statusProviders = map[string]func() interface{}{}
 )
 
+// RegisterStatus adds the value returned by provider to the health response under name
+func RegisterStatus(name string, provider func() interface{}) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	statusProviders[name] = provider
+}
+
 //PongHandler checks health of service
 func PongHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
@@ -26,6 +38,14 @@ func PongHandler(w http.ResponseWriter, r *http.Request) {
 	healthResponse := &Response{
 		BuildVersion: Version,
 	}
+	statusMutex.RLock()
+	for name, provider := range statusProviders {
+		if healthResponse.Status == nil {
+			healthResponse.Status = map[string]interface{}{}
+		}
+		healthResponse.Status[name] = provider()
+	}
+	statusMutex.RUnlock()
 
 	data, _ := json.Marshal(healthResponse)
 
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth_webhook"
+
+// Results of a login attempt
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+// Results of a TokenReview
+const (
+	ReviewAuthenticated   = "authenticated"
+	ReviewUnauthenticated = "unauthenticated"
+	ReviewBadRequest      = "bad_request"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route name, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests by route name.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"route"})
+
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Login attempts by result and reason.",
+	}, []string{"result", "reason"})
+
+	tokenReviews = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_reviews_total",
+		Help:      "TokenReview requests by result.",
+	}, []string{"result"})
+
+	authorizationDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorization_decisions_total",
+		Help:      "SubjectAccessReview decisions by decision, verb and resource.",
+	}, []string{"decision", "verb", "resource"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests refused by the rate limits by route name.",
+	}, []string{"route"})
+
+	userStoreReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_store_reloads_total",
+		Help:      "Loads of the user files by source and result.",
+	}, []string{"source", "result"})
+
+	userStoreLastReload = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "user_store_last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful load of the user files.",
+	}, []string{"source"})
+
+	userStoreUsers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "user_store_users",
+		Help:      "Users loaded from the user files.",
+	}, []string{"source"})
+)
+
+var promHandler = promhttp.Handler()
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, loginAttempts, tokenReviews, authorizationDecisions,
+		rateLimited, userStoreReloads, userStoreLastReload, userStoreUsers)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	promHandler.ServeHTTP(w, r)
+}
+
+// ObserveRequest records a handled HTTP request of the route
+func ObserveRequest(route, method string, code int, elapsed time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+}
+
+// LoginAttempt records a login, reason is a short fixed description like invalid_credentials
+func LoginAttempt(result, reason string) {
+	loginAttempts.WithLabelValues(result, reason).Inc()
+}
+
+// TokenReview records the result of a TokenReview
+func TokenReview(result string) {
+	tokenReviews.WithLabelValues(result).Inc()
+}
+
+// AuthorizationDecision records a SubjectAccessReview decision. Non resource requests have
+// the resource nonResource so that request paths do not become labels
+func AuthorizationDecision(decision, verb, resource string) {
+	authorizationDecisions.WithLabelValues(decision, verb, resource).Inc()
+}
+
+// RateLimited records a request refused by the rate limits
+func RateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
+
+// UserStoreReload records a load of the user files of source
+func UserStoreReload(source string, users int, err error) {
+	if err != nil {
+		userStoreReloads.WithLabelValues(source, "failure").Inc()
+		return
+	}
+	userStoreReloads.WithLabelValues(source, "success").Inc()
+	userStoreLastReload.WithLabelValues(source).SetToCurrentTime()
+	userStoreUsers.WithLabelValues(source).Set(float64(users))
+}
+
+// RegisterCertificateExpiry exposes the expiry of the serving certificate returned by notAfter
+func RegisterCertificateExpiry(notAfter func() time.Time) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Expiry time of the serving certificate in use.",
+	}, func() float64 { return float64(notAfter().Unix()) }))
+}
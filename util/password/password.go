@@ -0,0 +1,376 @@
+package password
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Supported hash algorithms
+const (
+	Bcrypt       = "bcrypt"
+	Argon2id     = "argon2id"
+	PBKDF2SHA256 = "pbkdf2-sha256"
+	PBKDF2SHA512 = "pbkdf2-sha512"
+)
+
+// Algorithms lists the algorithms Hash can generate
+var Algorithms = []string{Bcrypt, Argon2id, PBKDF2SHA256, PBKDF2SHA512}
+
+const (
+	saltLength       = 16
+	argon2Memory     = 64 * 1024
+	argon2Time       = 3
+	argon2Threads    = 4
+	argon2KeyLength  = 32
+	pbkdf2Iterations = 310000
+)
+
+// ab64 is the adapted base64 used by passlib for PBKDF2 hashes("." instead of "+", no padding)
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// IsHashed reports whether the stored value is a hash of a supported algorithm
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$") ||
+		strings.HasPrefix(stored, "$argon2id$") || strings.HasPrefix(stored, "$pbkdf2-") ||
+		strings.HasPrefix(stored, "$apr1$") || strings.HasPrefix(stored, "{SHA}")
+}
+
+// Verify compares the password with the stored value. The algorithm is detected from the prefix of
+// the stored value, values without a known prefix are compared as plain text. All comparisons are constant time.
+// Apache htpasswd APR1 and SHA1 hashes are accepted for verification only, Hash never generates them.
+func Verify(stored, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return verifyArgon2id(stored, password)
+	case strings.HasPrefix(stored, "$pbkdf2-"):
+		return verifyPBKDF2(stored, password)
+	case strings.HasPrefix(stored, "$apr1$"):
+		return verifyAPR1(stored, password)
+	case strings.HasPrefix(stored, "{SHA}"):
+		// htpasswd -s, base64 of the unsalted SHA1 of the password
+		sum := sha1.Sum([]byte(password))
+		computed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(computed)) == 1, nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, nil
+	}
+}
+
+// dummyHash is a bcrypt hash of a random value nobody knows, with the cost Hash uses
+const dummyHash = "$2a$10$G37Bwy0lSJZBl6/oYeXp/OXWtJJcC7aIWGBnMZ1Doh7bluDEfRT.e"
+
+// VerifyDummy takes as long as verifying a bcrypt hash generated by Hash, but never matches. Stores verify
+// the password of unknown users with it so that the response time does not tell whether a user exists
+func VerifyDummy(password string) {
+	bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+}
+
+var (
+	costMutex sync.Mutex
+	// costs caches the measured verification time by algorithm and parameters, refer costKey
+	costs = map[string]time.Duration{}
+)
+
+// VerifyCost returns how long verifying the slowest of the stored values takes, at least as long as VerifyDummy.
+// The time of each algorithm and parameters is measured once with a wrong password and cached.
+// Stores pad failed logins to it, so that the response time tells neither whether a user exists nor
+// which hash the user has
+func VerifyCost(stored []string) time.Duration {
+	costMutex.Lock()
+	defer costMutex.Unlock()
+	slowest := time.Duration(0)
+	for _, value := range append([]string{dummyHash}, stored...) {
+		key := costKey(value)
+		if key == "" {
+			continue
+		}
+		cost, ok := costs[key]
+		if !ok {
+			start := time.Now()
+			Verify(value, "not-the-password")
+			cost = time.Since(start)
+			costs[key] = cost
+		}
+		if cost > slowest {
+			slowest = cost
+		}
+	}
+	return slowest
+}
+
+// costKey returns the algorithm and parameters of the stored value, that decide how long verifying takes.
+// Plain text values and unknown formats are verified at once and have no key
+func costKey(stored string) string {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		// $2a$<cost>$<salt and hash>
+		if len(stored) > 7 {
+			return stored[:7]
+		}
+	case strings.HasPrefix(stored, "$argon2id$"), strings.HasPrefix(stored, "$pbkdf2-"):
+		// The parameters without the salt and hash, plus the key length
+		parts := strings.Split(stored, "$")
+		if len(parts) > 3 {
+			return strings.Join(parts[:len(parts)-2], "$") + "$" + strconv.Itoa(len(parts[len(parts)-1]))
+		}
+	case strings.HasPrefix(stored, "$apr1$"):
+		return "$apr1$"
+	case strings.HasPrefix(stored, "{SHA}"):
+		return "{SHA}"
+	}
+	return ""
+}
+
+// Hash returns the hash of the password using the algorithm
+func Hash(algorithm, password string) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hashed), err
+	case Argon2id:
+		salt, err := newSalt()
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case PBKDF2SHA256, PBKDF2SHA512:
+		salt, err := newSalt()
+		if err != nil {
+			return "", err
+		}
+		digest, size := pbkdf2Digest(algorithm)
+		key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, size, digest)
+		return fmt.Sprintf("$%s$%d$%s$%s", algorithm, pbkdf2Iterations, ab64.EncodeToString(salt), ab64.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("Unsupported algorithm %q, expected one of %s", algorithm, strings.Join(Algorithms, ", "))
+	}
+}
+
+// minKeyLength is the shortest derived key accepted in a stored hash. An empty key would match any password
+const minKeyLength = 16
+
+// Check reports whether a stored value that IsHashed can be verified, without the cost of verifying it.
+// Stores check the hashes when they load the users, so that a broken entry fails the load
+func Check(stored string) error {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		_, err := bcrypt.Cost([]byte(stored))
+		return err
+	case strings.HasPrefix(stored, "$argon2id$"):
+		_, err := parseArgon2id(stored)
+		return err
+	case strings.HasPrefix(stored, "$pbkdf2-"):
+		_, err := parsePBKDF2(stored)
+		return err
+	case strings.HasPrefix(stored, "$apr1$"):
+		if parts := strings.Split(stored, "$"); len(parts) != 4 || len(parts[3]) != 22 {
+			return errors.New("Invalid APR1 hash")
+		}
+		return nil
+	case strings.HasPrefix(stored, "{SHA}"):
+		if sum, err := base64.StdEncoding.DecodeString(stored[len("{SHA}"):]); err != nil || len(sum) != sha1.Size {
+			return errors.New("Invalid SHA1 hash")
+		}
+		return nil
+	default:
+		return errors.New("Unsupported hash")
+	}
+}
+
+type argon2idHash struct {
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// parseArgon2id parses a hash in the PHC format $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func parseArgon2id(stored string) (argon2idHash, error) {
+	var h argon2idHash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return h, errors.New("Invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, fmt.Errorf("Unsupported argon2id version %s", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, fmt.Errorf("Invalid argon2id parameters %s", parts[3])
+	}
+	// argon2.IDKey panics with zero rounds or threads, a broken user file must not crash the login
+	if h.memory < 1 || h.time < 1 || h.threads < 1 {
+		return h, fmt.Errorf("Invalid argon2id parameters %s", parts[3])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, errors.New("Invalid argon2id salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) < minKeyLength {
+		return h, errors.New("Invalid argon2id hash")
+	}
+	return h, nil
+}
+
+// verifyArgon2id verifies a hash in the PHC format $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func verifyArgon2id(stored, password string) (bool, error) {
+	h, err := parseArgon2id(stored)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(h.key, computed) == 1, nil
+}
+
+type pbkdf2Hash struct {
+	digest     func() hash.Hash
+	iterations int
+	salt, key  []byte
+}
+
+// parsePBKDF2 parses a hash in the passlib format $pbkdf2-sha256$<iterations>$<salt>$<hash>
+func parsePBKDF2(stored string) (pbkdf2Hash, error) {
+	var h pbkdf2Hash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 5 {
+		return h, errors.New("Invalid PBKDF2 hash")
+	}
+	if h.digest, _ = pbkdf2Digest(parts[1]); h.digest == nil {
+		return h, fmt.Errorf("Unsupported PBKDF2 digest %s", parts[1])
+	}
+	var err error
+	if h.iterations, err = strconv.Atoi(parts[2]); err != nil || h.iterations <= 0 {
+		return h, errors.New("Invalid PBKDF2 iterations")
+	}
+	if h.salt, err = ab64.DecodeString(parts[3]); err != nil {
+		return h, errors.New("Invalid PBKDF2 salt")
+	}
+	if h.key, err = ab64.DecodeString(parts[4]); err != nil || len(h.key) < minKeyLength {
+		return h, errors.New("Invalid PBKDF2 hash")
+	}
+	return h, nil
+}
+
+// verifyPBKDF2 verifies a hash in the passlib format $pbkdf2-sha256$<iterations>$<salt>$<hash>
+func verifyPBKDF2(stored, password string) (bool, error) {
+	h, err := parsePBKDF2(stored)
+	if err != nil {
+		return false, err
+	}
+	computed := pbkdf2.Key([]byte(password), h.salt, h.iterations, len(h.key), h.digest)
+	return subtle.ConstantTimeCompare(h.key, computed) == 1, nil
+}
+
+// verifyAPR1 verifies an Apache MD5 hash $apr1$<salt>$<hash> as generated by htpasswd -m
+func verifyAPR1(stored, password string) (bool, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 {
+		return false, errors.New("Invalid APR1 hash")
+	}
+	computed := apr1(password, parts[2])
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(computed)) == 1, nil
+}
+
+// apr1 is the MD5 based crypt of FreeBSD with the magic string $apr1$ used by Apache
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	ctx.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alt[:])
+		} else {
+			ctx.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(magic + salt + "$")
+	encode := func(value uint, chars int) {
+		for ; chars > 0; chars-- {
+			sb.WriteByte(itoa64[value&0x3f])
+			value >>= 6
+		}
+	}
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[group[0]])<<16|uint(final[group[1]])<<8|uint(final[group[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+	return sb.String()
+}
+
+func pbkdf2Digest(algorithm string) (func() hash.Hash, int) {
+	switch algorithm {
+	case PBKDF2SHA256:
+		return sha256.New, sha256.Size
+	case PBKDF2SHA512:
+		return sha512.New, sha512.Size
+	default:
+		return nil, 0
+	}
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	_, err := rand.Read(salt)
+	return salt, err
+}
@@ -0,0 +1,62 @@
+package password
+
+import (
+	"testing"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range Algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			hashed, err := Hash(algorithm, "secret")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if err := Check(hashed); err != nil {
+				t.Errorf("Check(%s): %v", hashed, err)
+			}
+			if ok, err := Verify(hashed, "secret"); !ok || err != nil {
+				t.Errorf("Verify with the password = %v, %v", ok, err)
+			}
+			if ok, _ := Verify(hashed, "wrong"); ok {
+				t.Error("Verify with a wrong password matched")
+			}
+		})
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	malformed := []string{
+		// An empty derived key would match any password
+		"$pbkdf2-sha256$1$c2FsdA$",
+		"$pbkdf2-sha256$1$c2FsdA$YQ",
+		"$argon2id$v=19$m=8,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=65536,t=0,p=4$lo9WfGjNVHBRamh7SpRuJg$IivKIsBLi8U8SQfjehf1S9/0hPzvBV5q+5DfpDYpeNo",
+		"$argon2id$v=19$m=65536,t=3,p=0$lo9WfGjNVHBRamh7SpRuJg$IivKIsBLi8U8SQfjehf1S9/0hPzvBV5q+5DfpDYpeNo",
+		"$pbkdf2-sha256$0$2yI97ZdSR.Xz4aj3YVe89Q$mQAqmd9TzkPm6ZwlpSg8Kf2yDvXk0/.uiwWiXsoQe2E",
+		"$2a$10$tooshort",
+		"$apr1$salt$",
+		"{SHA}",
+	}
+	for _, stored := range malformed {
+		if err := Check(stored); err == nil {
+			t.Errorf("Check(%q) accepted a malformed hash", stored)
+		}
+		if ok, _ := Verify(stored, "x"); ok {
+			t.Errorf("Verify(%q, \"x\") matched", stored)
+		}
+	}
+}
+
+func TestCheckSampleHashes(t *testing.T) {
+	// The hashes of config/user_details.yaml
+	for _, stored := range []string{
+		"$2a$10$bYWVMxfFaD0PI6MJb8WB7.cAYEEa4NhgYWUr.wXvYZ.5lkGx11c4u",
+		"$argon2id$v=19$m=65536,t=3,p=4$lo9WfGjNVHBRamh7SpRuJg$IivKIsBLi8U8SQfjehf1S9/0hPzvBV5q+5DfpDYpeNo",
+		"$pbkdf2-sha256$310000$2yI97ZdSR.Xz4aj3YVe89Q$mQAqmd9TzkPm6ZwlpSg8Kf2yDvXk0/.uiwWiXsoQe2E",
+		"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+	} {
+		if err := Check(stored); err != nil {
+			t.Errorf("Check(%q): %v", stored, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/util/metrics"
 
 	"github.com/gorilla/mux"
 )
@@ -38,11 +39,12 @@ const DELETE string = "DELETE"
 //Routes paths
 type Routes []Route
 
-//BuildRouter Builds a Mux router from the given route definitions
-func BuildRouter(routes Routes) *mux.Router {
+// BuildRouter Builds a Mux router from the given route definitions, the middlewares run after the request logging
+func BuildRouter(routes Routes, middlewares ...mux.MiddlewareFunc) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
 	router.Use(loggingMiddleware(router))
+	router.Use(middlewares...)
 
 	for _, route := range routes {
 		router.
@@ -75,10 +77,27 @@ func loggingMiddleware(router *mux.Router) func(next http.Handler) http.Handler
 				routeName = match.Route.GetName()
 			}
 
-			log.Debugf("Request received: [%s] %s %s", routeName, r.Method, r.RequestURI)
-			defer log.Debugf("Request handled in: %s", time.Since(start))
+			r = log.WithRequestFields(r, routeName)
+			w.Header().Set(log.RequestIDHeader, log.RequestID(r))
+			log.WithRequest(r).Debugf("Request received: %s %s", r.Method, r.URL.Path)
 
-			next.ServeHTTP(w, r)
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			elapsed := time.Since(start)
+			metrics.ObserveRequest(routeName, r.Method, recorder.status, elapsed)
+			log.WithRequest(r).WithField("status", recorder.status).Debugf("Request handled in: %s", elapsed)
 		})
 	}
 }
+
+// statusRecorder keeps the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
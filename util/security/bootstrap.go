@@ -0,0 +1,215 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+// Files written to the self-signed directory
+const (
+	BootstrapCACert = "ca.crt"
+	BootstrapCAKey  = "ca.key"
+	BootstrapCert   = "tls.crt"
+	BootstrapKey    = "tls.key"
+)
+
+const (
+	defaultBootstrapValidity = 365 * 24 * time.Hour
+	bootstrapCAValidity      = 10 * 365 * 24 * time.Hour
+	// The CA and the serving certificate are issued again when less than this is left of their validity
+	bootstrapRenewBefore = 30 * 24 * time.Hour
+)
+
+// BootstrapCertificates makes sure the directory has a CA and a serving certificate for the hosts signed
+// by it. Existing files are reused. Returns the PEM encoded CA certificate.
+func BootstrapCertificates(conf types.SelfSignedConfig) ([]byte, error) {
+	if len(conf.Hosts) == 0 {
+		return nil, errors.New("authConfig.tls.selfSigned.hosts is mandatory for self-signed certificates")
+	}
+	validity := defaultBootstrapValidity
+	if conf.Validity != "" {
+		var err error
+		if validity, err = time.ParseDuration(conf.Validity); err != nil {
+			return nil, fmt.Errorf("Invalid authConfig.tls.selfSigned.validity : %v", err)
+		}
+	}
+	if err := os.MkdirAll(conf.Directory, 0700); err != nil {
+		return nil, err
+	}
+
+	caCert, caKey, err := readKeyPair(filepath.Join(conf.Directory, BootstrapCACert), filepath.Join(conf.Directory, BootstrapCAKey))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if caCert == nil || time.Now().Add(bootstrapRenewBefore).After(caCert.NotAfter) {
+		log.Infof("Generating self-signed CA in %s", conf.Directory)
+		caCert, caKey, err = newCertificate(certificateTemplate("auth-webhook-sample CA", nil, bootstrapCAValidity, true), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKeyPair(conf.Directory, BootstrapCACert, BootstrapCAKey, caCert, caKey); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, _, err := readKeyPair(filepath.Join(conf.Directory, BootstrapCert), filepath.Join(conf.Directory, BootstrapKey))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if reason := reissueReason(cert, caCert, conf.Hosts); reason != "" {
+		log.Infof("Issuing serving certificate for %s in %s, %s", strings.Join(conf.Hosts, ", "), conf.Directory, reason)
+		template := certificateTemplate(conf.Hosts[0], conf.Hosts, validity, false)
+		// A certificate can not outlive its CA, the CA is renewed with it when it expires
+		if template.NotAfter.After(caCert.NotAfter) {
+			template.NotAfter = caCert.NotAfter
+		}
+		cert, key, err := newCertificate(template, caCert, caKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKeyPair(conf.Directory, BootstrapCert, BootstrapKey, cert, key); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Infof("Reusing serving certificate in %s valid until %s", conf.Directory, cert.NotAfter.Format(time.RFC3339))
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), nil
+}
+
+// WebhookKubeconfig returns a kubeconfig for the kube-apiserver to call the webhook endpoint at path
+func WebhookKubeconfig(name string, serverURL string, caPEM []byte, token string) string {
+	var sb strings.Builder
+	sb.WriteString("apiVersion: v1\nkind: Config\nclusters:\n")
+	fmt.Fprintf(&sb, "  - name: %s\n    cluster:\n      certificate-authority-data: %s\n      server: %s\n",
+		name, b64.StdEncoding.EncodeToString(caPEM), serverURL)
+	fmt.Fprintf(&sb, "users:\n  - name: %s-api-server\n", name)
+	if token != "" {
+		fmt.Fprintf(&sb, "    user:\n      token: %s\n", token)
+	} else {
+		sb.WriteString("    user: {}\n")
+	}
+	fmt.Fprintf(&sb, "current-context: webhook\ncontexts:\n- context:\n    cluster: %s\n    user: %s-api-server\n  name: webhook\n", name, name)
+	return sb.String()
+}
+
+// reissueReason returns why the serving certificate has to be issued again, empty if it can be reused
+func reissueReason(cert *x509.Certificate, caCert *x509.Certificate, hosts []string) string {
+	if cert == nil {
+		return "no certificate found"
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		return "not signed by the CA"
+	}
+	if time.Now().Add(bootstrapRenewBefore).After(cert.NotAfter) {
+		return "expires " + cert.NotAfter.Format(time.RFC3339)
+	}
+	var current []string
+	current = append(current, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		current = append(current, ip.String())
+	}
+	wanted := append([]string{}, hosts...)
+	sort.Strings(current)
+	sort.Strings(wanted)
+	if strings.Join(current, ",") != strings.Join(wanted, ",") {
+		return "hosts changed"
+	}
+	return ""
+}
+
+func certificateTemplate(commonName string, hosts []string, validity time.Duration, isCA bool) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		return template
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return template
+}
+
+// newCertificate creates a P-256 key and a certificate for it signed by the parent, self-signed if parent is nil
+func newCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func readKeyPair(certPath string, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("No PEM data in %s or %s", certPath, keyPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(dir string, certName string, keyName string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	var certPEM bytes.Buffer
+	pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, keyName), pem.EncodeToMemory(pemBlockForKey(key)), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, certName), certPEM.Bytes(), 0644)
+}
@@ -6,71 +6,28 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	b64 "encoding/base64"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"regexp"
-	"runtime"
 
 	"github.com/dinumathai/auth-webhook-sample/log"
 )
 
-var basePath string
-
-// CrtPath will be the path to the cert file for tls
-var CrtPath string
-
-// KeyPath will be the path to the cert file for tls
-var KeyPath string
-
-func init() {
-	basePath = getBasePath()
-	CrtPath = basePath + string(os.PathSeparator) + "tls.crt"
-	KeyPath = basePath + string(os.PathSeparator) + "tls.key"
-}
-
-//LoadCertsFromEnvVariable - Pull and Load SSL Certs for HTTPS..
-func LoadCertsFromEnvVariable(crtEnvVar string, keyEnvVar string) error {
-	err := createDirectoryIfNotExist(getBasePath())
-	if err != nil {
-		return err
-	}
-
-	err = writeFile(GetCertificatePath(), crtEnvVar)
-	if err != nil {
-		return err
-	}
-
-	err = writeFile(GetKeyPath(), keyEnvVar)
+// CertificateFromEnvVariable - Parse the SSL cert and key for HTTPS from env variables, PEM or base64 encoded PEM
+func CertificateFromEnvVariable(crtEnvVar string, keyEnvVar string) (tls.Certificate, error) {
+	crt, err := decodeIfEncoded(os.Getenv(crtEnvVar))
 	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func createDirectoryIfNotExist(dirPath string) error {
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		err = os.MkdirAll(dirPath, 0755)
-		if err != nil {
-			return err
-		}
+		return tls.Certificate{}, err
 	}
-	return nil
-}
-
-func writeFile(path string, envVar string) error {
-	decodedString, err := decodeIfEncoded(os.Getenv(envVar))
+	key, err := decodeIfEncoded(os.Getenv(keyEnvVar))
 	if err != nil {
-		return err
+		return tls.Certificate{}, err
 	}
-
-	err = ioutil.WriteFile(path, []byte(decodedString), 0644)
-
-	return err
+	return tls.X509KeyPair([]byte(crt), []byte(key))
 }
 
 func decodeIfEncoded(envVar string) (string, error) {
@@ -90,22 +47,6 @@ func decodeIfEncoded(envVar string) (string, error) {
 	return envVar, nil
 }
 
-func getBasePath() string {
-	if runtime.GOOS == "windows" {
-		return "C:\\cert-temp-auth" // kinda lame
-	} else {
-		return "/tmp/cert-temp-auth"
-	}
-}
-
-func GetCertificatePath() string {
-	return getBasePath() + string(os.PathSeparator) + "tls.crt"
-}
-
-func GetKeyPath() string {
-	return getBasePath() + string(os.PathSeparator) + "tls.key"
-}
-
 func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
@@ -0,0 +1,136 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dinumathai/auth-webhook-sample/log"
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+// CertificateReloader serves the certificate of a cert and key file pair and loads it again when the files
+// change, e.g. when cert-manager renews it. The previous certificate is kept if the new files do not load.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	status  types.CertificateStatus
+	modTime time.Time
+}
+
+// NewCertificateReloader loads the certificate from the files
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	cr := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		status:   types.CertificateStatus{CertFile: certFile},
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// StaticCertificate returns a reloader that always serves the certificate, used for certificates from env variables
+func StaticCertificate(cert tls.Certificate) *CertificateReloader {
+	cr := &CertificateReloader{cert: &cert}
+	cr.status = certificateStatus(cert)
+	cr.status.LastAttempt = cr.status.LastReload
+	return cr
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate
+func (cr *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.cert, nil
+}
+
+// Status returns the certificate in use and the outcome of the last reload
+func (cr *CertificateReloader) Status() types.CertificateStatus {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.status
+}
+
+// Watch reloads the certificate when the modification time of the files changes and on SIGHUP
+func (cr *CertificateReloader) Watch(interval time.Duration) {
+	if cr.certFile == "" {
+		return
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	go func() {
+		for {
+			select {
+			case <-hangup:
+			case <-tick:
+				if cr.latestModTime().Equal(cr.lastModTime()) {
+					continue
+				}
+			}
+			if err := cr.reload(); err != nil {
+				log.Errorf("Reloading TLS certificate %s failed, keeping the current certificate : %v", cr.certFile, err)
+			}
+		}
+	}()
+}
+
+func (cr *CertificateReloader) reload() error {
+	modTime := cr.latestModTime()
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.modTime = modTime
+	cr.status.LastAttempt = time.Now()
+	if err != nil {
+		cr.status.LastError = err.Error()
+		return err
+	}
+	cr.cert = &cert
+	status := certificateStatus(cert)
+	status.CertFile = cr.certFile
+	status.LastAttempt = cr.status.LastAttempt
+	cr.status = status
+	log.Infof("Loaded TLS certificate %s of %q valid until %s", cr.certFile, status.Subject, status.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (cr *CertificateReloader) lastModTime() time.Time {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.modTime
+}
+
+func (cr *CertificateReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func certificateStatus(cert tls.Certificate) types.CertificateStatus {
+	status := types.CertificateStatus{LastReload: time.Now()}
+	if len(cert.Certificate) != 0 {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			status.Subject = leaf.Subject.CommonName
+			status.NotAfter = leaf.NotAfter
+		}
+	}
+	return status
+}
@@ -0,0 +1,140 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/dinumathai/auth-webhook-sample/types"
+)
+
+// Client certificate modes of the HTTPS server
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequireAndVerify = "require-and-verify"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSConfig builds the tls.Config of the HTTPS server. With the request mode a client certificate
+// is asked for and verified if one is sent, with require-and-verify connections without a valid client
+// certificate are refused. Client certificates must match one of the allowed names if any are configured.
+func ServerTLSConfig(conf types.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.MinVersion != "" {
+		version, ok := tlsVersions[conf.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported authConfig.tls.minVersion %q, expected 1.2 or 1.3", conf.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(conf.CipherSuites) != 0 {
+		known := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			known[suite.Name] = suite.ID
+		}
+		for _, name := range conf.CipherSuites {
+			id, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown or insecure cipher suite %s in authConfig.tls.cipherSuites", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+		if !hasHTTP2CipherSuite(tlsConfig.CipherSuites) {
+			return nil, errors.New("authConfig.tls.cipherSuites must have TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, HTTP/2 requires one of them")
+		}
+	}
+
+	if conf.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("No certificates found in %s", conf.ClientCAFile)
+		}
+	}
+
+	switch conf.ClientAuth {
+	case "", ClientAuthNone:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case ClientAuthRequest:
+		tlsConfig.ClientAuth = tls.RequestClientCert
+		if tlsConfig.ClientCAs != nil {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	case ClientAuthRequireAndVerify:
+		if tlsConfig.ClientCAs == nil {
+			return nil, errors.New("authConfig.tls.clientCAFile is mandatory for clientAuth require-and-verify")
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("Unsupported authConfig.tls.clientAuth %q, expected %s, %s or %s", conf.ClientAuth,
+			ClientAuthNone, ClientAuthRequest, ClientAuthRequireAndVerify)
+	}
+
+	if len(conf.AllowedClientNames) != 0 {
+		// The names of an unverified certificate can be anything, matching them would restrict nothing
+		switch tlsConfig.ClientAuth {
+		case tls.NoClientCert:
+			return nil, errors.New("authConfig.tls.allowedClientNames has no effect without client certificates, set authConfig.tls.clientAuth")
+		case tls.RequestClientCert:
+			return nil, errors.New("authConfig.tls.clientCAFile is mandatory for authConfig.tls.allowedClientNames, certificates are not verified without it")
+		}
+		for _, pattern := range conf.AllowedClientNames {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid pattern %q in authConfig.tls.allowedClientNames", pattern)
+			}
+		}
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return nil
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if !MatchCertificateName(cert, conf.AllowedClientNames) {
+				return fmt.Errorf("Client certificate %q not in authConfig.tls.allowedClientNames", cert.Subject.CommonName)
+			}
+			return nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// MatchCertificateName reports whether the common name or one of the SANs(DNS, email, URI) of the
+// certificate matches one of the patterns
+func MatchCertificateName(cert *x509.Certificate, patterns []string) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if matched, _ := path.Match(pattern, name); matched && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasHTTP2CipherSuite(suites []uint16) bool {
+	for _, suite := range suites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}